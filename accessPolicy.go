@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// AccessConfig configures which files of the docroot are hidden, hidden files result in a 404
+type AccessConfig struct {
+	// Dotfiles serves files and directories whose name starts with a dot (default: hidden)
+	Dotfiles bool `yaml:"dotfiles"`
+	// Deny hides files and directories matching these globs (e.g. *.bak or /private/**)
+	Deny []string `yaml:"deny"`
+	// Allow serves files matching these globs even if they are dotfiles or denied (e.g. /.well-known/**)
+	Allow []string `yaml:"allow"`
+	deny globList
+	allow globList
+}
+
+func (c *AccessConfig) finalize() error {
+	var err error
+	if c.deny, err = compileGlobs(c.Deny); err != nil {
+		return err
+	}
+	c.allow, err = compileGlobs(c.Allow)
+	return err
+}
+
+// hidden returns whether the file or one of its parent directories must not be served.
+// A nil config hides dotfiles.
+func (c *AccessConfig) hidden(name string) bool {
+	name = path.Clean("/" + name)
+	if isRuleFile(name) {
+		return true
+	}
+	if name == "/" {
+		return false
+	}
+	var allow, deny globList
+	dotfiles := false
+	if c != nil {
+		allow, deny, dotfiles = c.allow, c.deny, c.Dotfiles
+	}
+	// allow globs match the file itself, so that allowing a directory doesn't serve the dotfiles below it
+	if allow.match(name) {
+		return false
+	}
+	// parent directories are checked as well, so that e.g. .git or /private/** hide everything below them
+	for i := len(name); i > 0; i = strings.LastIndexByte(name[:i], '/') {
+		dir := name[:i]
+		if !dotfiles && strings.HasPrefix(path.Base(dir), ".") || deny.match(dir) {
+			return true
+		}
+	}
+	return false
+}
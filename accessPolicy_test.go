@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	access := &AccessConfig{Deny: []string{"*.bak", "/private/**"}, Allow: []string{"/.well-known/**", "/private/public.txt", "/public"}}
+	if err := access.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	tests := []struct {
+		name string
+		access *AccessConfig
+		path string
+		hidden bool
+	}{
+		{name: "Regular file", access: nil, path: "/index.html", hidden: false},
+		{name: "Root", access: nil, path: "/", hidden: false},
+		{name: "Dotfile by default", access: nil, path: "/.env", hidden: true},
+		{name: "File in dot directory", access: nil, path: "/.git/config", hidden: true},
+		{name: "Unclean path", access: nil, path: "/assets/../.git/config", hidden: true},
+		{name: "Rule file", access: nil, path: "/_redirects", hidden: true},
+		{name: "Dotfiles allowed", access: &AccessConfig{Dotfiles: true}, path: "/.env", hidden: false},
+		{name: "Rule file with dotfiles allowed", access: &AccessConfig{Dotfiles: true}, path: "/_headers", hidden: true},
+		{name: "Denied by name", access: access, path: "/docs/index.html.bak", hidden: true},
+		{name: "Denied directory", access: access, path: "/private/secret.txt", hidden: true},
+		{name: "Allowed in denied directory", access: access, path: "/private/public.txt", hidden: false},
+		{name: "Allowed dot directory", access: access, path: "/.well-known/security.txt", hidden: false},
+		{name: "Other dotfiles still hidden", access: access, path: "/.htpasswd", hidden: true},
+		{name: "Allowed directory", access: access, path: "/public", hidden: false},
+		{name: "Dotfile in allowed directory", access: access, path: "/public/.env", hidden: true},
+		{name: "Dot directory in allowed directory", access: access, path: "/public/.git/config", hidden: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if hidden := test.access.hidden(test.path); hidden != test.hidden {
+				t.Fatalf("Expected %v but got %v", test.hidden, hidden)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyFileServer(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.Mkdir(tempDir + "/.git", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/.git/config", []byte("[core]"))
+	writeFile(tempDir + "/.env", []byte("SECRET=1"))
+	writeFile(tempDir + "/.404.html", []byte("hidden 404"))
+
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	error404File := "/.404.html"
+	h := HandleError404(&error404File, false, false, http.FileServer(fs))
+	for _, URL := range []string{"/.git/config", "/.git/", "/.env"} {
+		t.Run(URL, func(t *testing.T) {
+			r := httptest.NewRequest("GET", URL, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("Expected %v but got %v", http.StatusNotFound, rec.Code)
+			}
+			if body := rec.Body.String(); body != "404 page not found\n" {
+				t.Fatalf("Expected the default 404 page but got %v", body)
+			}
+		})
+	}
+}
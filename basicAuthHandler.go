@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// BasicAuthConfig protects a path prefix with HTTP Basic authentication
+type BasicAuthConfig struct {
+	// Path is the protected path prefix (default: /)
+	Path string `yaml:"path"`
+	// Htpasswd is an Apache htpasswd file with bcrypt, SHA or APR1 (MD5) hashes, it's reloaded when it changes
+	Htpasswd string `yaml:"htpasswd"`
+	// Realm is shown by browsers when asking for credentials (default: Restricted)
+	Realm string `yaml:"realm"`
+	users *htpasswdFile
+}
+
+func (c *BasicAuthConfig) finalize() error {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("path %s must start with /", c.Path)
+	}
+	if c.Htpasswd == "" {
+		return fmt.Errorf("no htpasswd file configured for %s", c.Path)
+	}
+	if c.Realm == "" {
+		c.Realm = "Restricted"
+	}
+	c.users = newHtpasswdFile(c.Htpasswd)
+	_, err := c.users.load()
+	return err
+}
+
+// protects returns whether the cleaned path is below the protected path prefix
+func (c *BasicAuthConfig) protects(cleanPath string) bool {
+	return belowPath(c.Path, cleanPath)
+}
+
+// belowPath returns whether the cleaned path is the prefix or below it, prefixes match whole path segments
+func belowPath(prefix string, cleanPath string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || cleanPath == prefix || strings.HasPrefix(cleanPath, prefix + "/")
+}
+
+// htpasswdFile keeps the users of an htpasswd file until its modification time or size changes
+type htpasswdFile struct {
+	reloadableFile
+}
+
+func newHtpasswdFile(name string) *htpasswdFile {
+	f := &htpasswdFile{reloadableFile{name: name, open: openOSFile}}
+	f.parse = f.parseUsers
+	return f
+}
+
+// parseUsers reads the users and their hashes, lines with unsupported hashes are ignored
+func (f *htpasswdFile) parseUsers(r io.Reader) (interface{}, error) {
+	users := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			log.Printf("Ignoring invalid line %d in %s", line, f.name)
+			continue
+		}
+		user, hash := text[:i], text[i+1:]
+		if !supportedHash(hash) {
+			log.Printf("Ignoring user %s in %s: unsupported hash, use bcrypt, SHA or APR1", user, f.name)
+			continue
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// verify returns whether the password of the user matches, the file is reloaded if it changed
+func (f *htpasswdFile) verify(user string, password string) bool {
+	users, err := f.load()
+	if err != nil {
+		log.Printf("Could not read %s: %v", f.name, err)
+		return false
+	}
+	hash, ok := users.(map[string]string)[user]
+	return ok && verifyPassword(hash, password)
+}
+
+func supportedHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") ||
+		strings.HasPrefix(hash, "{SHA}") || strings.HasPrefix(hash, "$apr1$")
+}
+
+func verifyPassword(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1(password, salt))) == 1
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+}
+
+// apr1 computes the Apache variant of the MD5 based crypt
+func apr1(password string, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic))
+	d.Write([]byte(salt))
+	alt := md5.Sum([]byte(password + salt + password))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(alt[:])
+		} else {
+			d.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i & 1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final := d.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i & 1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(final)
+		}
+		if i % 3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i % 7 != 0 {
+			d.Write(pw)
+		}
+		if i & 1 != 0 {
+			d.Write(final)
+		} else {
+			d.Write(pw)
+		}
+		final = d.Sum(nil)
+	}
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var b strings.Builder
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(itoa64[v & 0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[i[0]]) << 16 | uint32(final[i[1]]) << 8 | uint32(final[i[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+	return magic + salt + "$" + b.String()
+}
+
+// HandleBasicAuth requires HTTP Basic authentication for the protected path prefixes,
+// the longest matching prefix decides which htpasswd file and realm apply.
+func HandleBasicAuth(configs []BasicAuthConfig, h http.Handler) http.Handler {
+	if len(configs) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// http.FileServer cleans the path, so the cleaned path is protected
+		cleanPath := path.Clean("/" + r.URL.Path)
+		var config *BasicAuthConfig
+		for i := range configs {
+			if configs[i].protects(cleanPath) && (config == nil || len(configs[i].Path) > len(config.Path)) {
+				config = &configs[i]
+			}
+		}
+		if config == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		user, password, ok := r.BasicAuth()
+		if !ok || !config.users.verify(user, password) {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, config.Realm))
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
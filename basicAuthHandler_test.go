@@ -0,0 +1,105 @@
+package main
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApr1(t *testing.T) {
+	// generated with openssl passwd -apr1 -salt saltsalt secret
+	expected := "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"
+	if hash := apr1("secret", "saltsalt"); hash != expected {
+		t.Fatalf("Expected %v but got %v", expected, hash)
+	}
+}
+
+func TestBasicAuthHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pw"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/staging.htpasswd", []byte("# users\n" +
+		"alice:" + string(bcryptHash) + "\n" +
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"carol:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n" +
+		"dave:plaintext\n"))
+	writeFile(tempDir + "/admin.htpasswd", []byte("admin:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+
+	configs := []BasicAuthConfig{
+		{Path: "/staging", Htpasswd: tempDir + "/staging.htpasswd", Realm: "Staging"},
+		{Path: "/staging/admin/", Htpasswd: tempDir + "/admin.htpasswd"},
+	}
+	for i := range configs {
+		if err := configs[i].finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	h := HandleBasicAuth(configs, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+
+	tests := []struct {
+		name string
+		URL string
+		user string
+		password string
+		code int
+		realm string
+	}{
+		{name: "Unprotected path", URL: "/public/index.html", code: http.StatusOK},
+		{name: "Prefix is matched by path segments", URL: "/staging-other/x", code: http.StatusOK},
+		{name: "No credentials", URL: "/staging/index.html", code: http.StatusUnauthorized, realm: `Basic realm="Staging", charset="UTF-8"`},
+		{name: "Bcrypt", URL: "/staging/", user: "alice", password: "bcrypt-pw", code: http.StatusOK},
+		{name: "SHA", URL: "/staging", user: "bob", password: "password", code: http.StatusOK},
+		{name: "APR1", URL: "/staging/x", user: "carol", password: "secret", code: http.StatusOK},
+		{name: "Wrong password", URL: "/staging/x", user: "carol", password: "wrong", code: http.StatusUnauthorized},
+		{name: "Unsupported hash", URL: "/staging/x", user: "dave", password: "plaintext", code: http.StatusUnauthorized},
+		{name: "Unclean path", URL: "/public/../staging/x", code: http.StatusUnauthorized},
+		{name: "Longest prefix wins", URL: "/staging/admin/x", user: "alice", password: "bcrypt-pw", code: http.StatusUnauthorized, realm: `Basic realm="Restricted", charset="UTF-8"`},
+		{name: "Longest prefix user", URL: "/staging/admin/x", user: "admin", password: "password", code: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = test.URL
+			if test.user != "" {
+				r.SetBasicAuth(test.user, test.password)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			if test.realm != "" && rec.Header().Get("WWW-Authenticate") != test.realm {
+				t.Fatalf("Expected %v but got %v", test.realm, rec.Header().Get("WWW-Authenticate"))
+			}
+			if test.code == http.StatusOK && !strings.Contains(rec.Body.String(), "content") {
+				t.Fatalf("Expected the content to be served")
+			}
+		})
+	}
+
+	t.Run("Reloaded on change", func(t *testing.T) {
+		writeFile(tempDir + "/admin.htpasswd", []byte("root:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(tempDir + "/admin.htpasswd", later, later); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		for user, code := range map[string]int{"admin": http.StatusUnauthorized, "root": http.StatusOK} {
+			r := httptest.NewRequest("GET", "/staging/admin/", nil)
+			r.SetBasicAuth(user, "password")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != code {
+				t.Fatalf("Expected %v but got %v", code, rec.Code)
+			}
+		}
+	})
+}
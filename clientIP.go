@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipList is a list of networks, single addresses are networks of one address
+type ipList []*net.IPNet
+
+// parseIPList parses CIDRs like 10.0.0.0/8 and single addresses like 192.0.2.1 or 2001:db8::1
+func parseIPList(entries []string) (ipList, error) {
+	var list ipList
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s", entry)
+		}
+		list = append(list, network)
+	}
+	return list, nil
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	for _, network := range l {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+const clientIPKey = contextKey("clientIP")
+
+// clientIP returns the address of the client, resolved by HandleClientIP if the peer is a trusted proxy
+func clientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey).(net.IP); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// clientAddress returns the address of the client for logs, the peer address and port without trusted proxies
+func clientAddress(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(net.IP); ok {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedHeaders are the headers a trusted proxy may write the client address to
+var forwardedHeaders = []string{"X-Forwarded-For", "Forwarded"}
+
+// forwardedFor returns the addresses of the header written by the trusted proxies, from the client to the last proxy.
+// Other headers are ignored, as proxies pass them on unchanged from the client. Unknown and obfuscated addresses are nil.
+func forwardedFor(header http.Header, name string) []net.IP {
+	var addresses []net.IP
+	if name == "Forwarded" {
+		for _, element := range strings.Split(strings.Join(header.Values("Forwarded"), ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					addresses = append(addresses, parseForwardedAddress(strings.Trim(kv[1], `"`)))
+				}
+			}
+		}
+		return addresses
+	}
+	for _, entry := range strings.Split(strings.Join(header.Values("X-Forwarded-For"), ","), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			addresses = append(addresses, parseForwardedAddress(entry))
+		}
+	}
+	return addresses
+}
+
+// parseForwardedAddress parses addresses like 192.0.2.1, 192.0.2.1:4711 or [2001:db8::1]:4711
+func parseForwardedAddress(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(strings.Trim(address, "[]"))
+}
+
+// resolveClientIP walks the forwarded addresses from the peer towards the client while they are trusted proxies,
+// the first untrusted address is the client. Addresses added by the client itself are never trusted this way.
+func resolveClientIP(trusted ipList, forwardedHeader string, r *http.Request) net.IP {
+	ip := peerIP(r)
+	if ip == nil || !trusted.contains(ip) {
+		return ip
+	}
+	addresses := forwardedFor(r.Header, forwardedHeader)
+	for i := len(addresses) - 1; i >= 0; i-- {
+		if addresses[i] == nil {
+			// an unknown address hides the client, the last proxy is the best guess
+			return ip
+		}
+		ip = addresses[i]
+		if !trusted.contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// HandleClientIP resolves the client address from the forwarded header (X-Forwarded-For or Forwarded)
+// if the request comes from one of the trusted proxies, the headers of other peers are ignored.
+func HandleClientIP(trustedProxies ipList, forwardedHeader string, h http.Handler) http.Handler {
+	if len(trustedProxies) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := resolveClientIP(trustedProxies, forwardedHeader, r); ip != nil {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey, ip))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
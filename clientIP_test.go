@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseIPList([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	tests := []struct {
+		name string
+		remoteAddr string
+		forwardedHeader string
+		header map[string]string
+		expected string
+	}{
+		{name: "Untrusted peer", remoteAddr: "198.51.100.7:1234", header: map[string]string{"X-Forwarded-For": "203.0.113.9"}, expected: "198.51.100.7"},
+		{name: "Trusted peer", remoteAddr: "10.0.0.2:1234", header: map[string]string{"X-Forwarded-For": "203.0.113.9"}, expected: "203.0.113.9"},
+		{name: "Trusted IPv6 peer", remoteAddr: "[2001:db8::1]:1234", header: map[string]string{"X-Forwarded-For": "2001:db8::9"}, expected: "2001:db8::9"},
+		{name: "Spoofed by the client", remoteAddr: "10.0.0.2:1234", header: map[string]string{"X-Forwarded-For": "127.0.0.1, 203.0.113.9, 10.0.0.3"}, expected: "203.0.113.9"},
+		{name: "Only proxies", remoteAddr: "10.0.0.2:1234", header: map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, expected: "10.0.0.4"},
+		{name: "Without header", remoteAddr: "10.0.0.2:1234", expected: "10.0.0.2"},
+		{name: "Forwarded passed on from the client", remoteAddr: "10.0.0.2:1234", header: map[string]string{"Forwarded": "for=192.168.1.50", "X-Forwarded-For": "203.0.113.9"}, expected: "203.0.113.9"},
+		{name: "Forwarded without X-Forwarded-For", remoteAddr: "10.0.0.2:1234", header: map[string]string{"Forwarded": "for=192.168.1.50"}, expected: "10.0.0.2"},
+		{name: "Forwarded", remoteAddr: "10.0.0.2:1234", forwardedHeader: "Forwarded", header: map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`, "X-Forwarded-For": "203.0.113.9"}, expected: "2001:db8:cafe::17"},
+		{name: "X-Forwarded-For passed on from the client", remoteAddr: "10.0.0.2:1234", forwardedHeader: "Forwarded", header: map[string]string{"Forwarded": "for=203.0.113.9", "X-Forwarded-For": "192.168.1.50"}, expected: "203.0.113.9"},
+		{name: "Forwarded with port", remoteAddr: "10.0.0.2:1234", forwardedHeader: "Forwarded", header: map[string]string{"Forwarded": `for="192.0.2.60:8080";by=10.0.0.2`}, expected: "192.0.2.60"},
+		{name: "Unknown address", remoteAddr: "10.0.0.2:1234", forwardedHeader: "Forwarded", header: map[string]string{"Forwarded": "for=192.0.2.60, for=unknown"}, expected: "10.0.0.2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for name, value := range test.header {
+				r.Header.Set(name, value)
+			}
+			forwardedHeader := test.forwardedHeader
+			if forwardedHeader == "" {
+				forwardedHeader = "X-Forwarded-For"
+			}
+			ip := resolveClientIP(trusted, forwardedHeader, r)
+			if ip.String() != test.expected {
+				t.Fatalf("Expected %v but got %v", test.expected, ip)
+			}
+		})
+	}
+}
+
+func TestClientIPInAccessLog(t *testing.T) {
+	trusted, _ := parseIPList([]string{"10.0.0.0/8"})
+	h := HandleClientIP(trusted, "X-Forwarded-For", LogAccess(true, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	tests := []struct {
+		name string
+		remoteAddr string
+		expected string
+	}{
+		{name: "Trusted proxy", remoteAddr: "10.0.0.2:1234", expected: "203.0.113.9 204 0 /"},
+		{name: "Untrusted peer", remoteAddr: "198.51.100.7:1234", expected: "198.51.100.7 204 0 /"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			r.Header.Set("X-Forwarded-For", "203.0.113.9")
+			l := log.Writer()
+			buf := &bytes.Buffer{}
+			log.SetOutput(buf)
+			defer log.SetOutput(l)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if logStr := strings.TrimSpace(buf.String()); !strings.HasSuffix(logStr, test.expected) {
+				t.Fatalf("%v should end with %v", logStr, test.expected)
+			}
+		})
+	}
+}
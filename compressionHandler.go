@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CompressionConfig configures the compression of files which have no precompressed variants
+type CompressionConfig struct {
+	// MinSize is the size in bytes from which files are compressed (default: 1024)
+	MinSize int64 `yaml:"minSize"`
+	// MaxSize is the size in bytes up to which files are compressed, larger files are sent uncompressed (default: 10 MiB)
+	MaxSize int64 `yaml:"maxSize"`
+	// CacheSize is the number of bytes of compressed files kept in memory,
+	// the least recently used files are evicted beyond it (default: 64 MiB)
+	CacheSize int64 `yaml:"cacheSize"`
+	// Types are the compressible MIME types, a trailing * matches all subtypes (default: text and common text based types)
+	Types []string `yaml:"types"`
+}
+
+var defaultCompressibleTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/xml",
+	"application/wasm",
+	"image/svg+xml",
+	"image/x-icon",
+	"font/ttf",
+	"font/otf",
+}
+
+func (c *CompressionConfig) finalize() error {
+	if c.MinSize == 0 {
+		c.MinSize = 1024
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = 10 << 20
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = 64 << 20
+	}
+	if c.MinSize < 0 || c.MaxSize < 0 || c.CacheSize < 0 {
+		return fmt.Errorf("sizes must not be negative")
+	}
+	if c.MaxSize < c.MinSize {
+		return fmt.Errorf("maxSize %d must not be smaller than minSize %d", c.MaxSize, c.MinSize)
+	}
+	if len(c.Types) == 0 {
+		c.Types = defaultCompressibleTypes
+	}
+	return nil
+}
+
+// compressible returns whether files of the size and content type are compressed
+func (c *CompressionConfig) compressible(size int64, contentType string) bool {
+	return size >= c.MinSize && size <= c.MaxSize && matchesMimeType(c.Types, contentType)
+}
+
+// compressionEncodings are ordered by preference, if the client accepts several of them
+var compressionEncodings = []string{"br", "gzip"}
+
+func compress(encoding string, content []byte) ([]byte, error) {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "br" {
+		w = brotli.NewWriterLevel(&b, 9)
+	} else {
+		w, _ = gzip.NewWriterLevel(&b, gzip.BestCompression)
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+type compressedFile struct {
+	modTime time.Time
+	size int64
+	content []byte
+	lastUsed time.Time
+}
+
+// pendingCompression is a compression in progress, done is closed once content or err is set
+type pendingCompression struct {
+	done chan bool
+	content []byte
+	err error
+}
+
+// compressionCache keeps the compressed content of files.
+// Entries are identified by the name, modification time and size of the file,
+// so they are replaced as soon as a file changes (e.g. when the watcher of the in memory filesystem reloads it).
+// Beyond maxSize bytes of compressed content the least recently used entries are evicted,
+// which also drops the entries of removed files eventually.
+// Concurrent requests for a file which is being compressed wait for that compression instead of starting another one.
+type compressionCache struct {
+	lock sync.Mutex
+	maxSize int64
+	size int64
+	files map[string]*compressedFile
+	pending map[string]*pendingCompression
+}
+
+// newCompressionCache returns a cache holding up to maxSize bytes of compressed content, 0 means unlimited
+func newCompressionCache(maxSize int64) *compressionCache {
+	return &compressionCache{maxSize: maxSize, files: map[string]*compressedFile{}, pending: map[string]*pendingCompression{}}
+}
+
+func (c *compressionCache) get(name string, encoding string, fi os.FileInfo, f io.Reader) ([]byte, error) {
+	key := encoding + ":" + name
+	c.lock.Lock()
+	cached, ok := c.files[key]
+	if ok && cached.modTime.Equal(fi.ModTime()) && cached.size == fi.Size() {
+		cached.lastUsed = time.Now()
+		c.lock.Unlock()
+		return cached.content, nil
+	}
+	version := fmt.Sprintf("%s %d %d", key, fi.ModTime().UnixNano(), fi.Size())
+	if p, ok := c.pending[version]; ok {
+		c.lock.Unlock()
+		<-p.done
+		return p.content, p.err
+	}
+	p := &pendingCompression{done: make(chan bool)}
+	c.pending[version] = p
+	c.lock.Unlock()
+
+	content, err := ioutil.ReadAll(f)
+	if err == nil {
+		p.content, p.err = compress(encoding, content)
+	} else {
+		p.err = err
+	}
+	c.lock.Lock()
+	delete(c.pending, version)
+	if p.err == nil {
+		c.add(key, &compressedFile{modTime: fi.ModTime(), size: fi.Size(), content: p.content, lastUsed: time.Now()})
+	}
+	c.lock.Unlock()
+	close(p.done)
+	return p.content, p.err
+}
+
+// add caches the file and evicts the least recently used files beyond maxSize, the lock must be held
+func (c *compressionCache) add(key string, file *compressedFile) {
+	if old, ok := c.files[key]; ok {
+		c.size -= int64(len(old.content))
+		delete(c.files, key)
+	}
+	if c.maxSize > 0 && int64(len(file.content)) > c.maxSize {
+		return
+	}
+	c.files[key] = file
+	c.size += int64(len(file.content))
+	for c.maxSize > 0 && c.size > c.maxSize {
+		oldest := ""
+		for k, f := range c.files {
+			if k != key && (oldest == "" || f.lastUsed.Before(c.files[oldest].lastUsed)) {
+				oldest = k
+			}
+		}
+		c.size -= int64(len(c.files[oldest].content))
+		delete(c.files, oldest)
+	}
+}
+
+// precompute compresses all compressible files below the docroot
+func (c *compressionCache) precompute(config *CompressionConfig, fs http.FileSystem, docroot string) {
+	walkFiles(fs, docroot, func(name string, f http.File, fi os.FileInfo) {
+		if !config.compressible(fi.Size(), contentType(name, f)) {
+			return
+		}
+		for _, encoding := range compressionEncodings {
+			f.Seek(0, io.SeekStart)
+			if _, err := c.get(name, encoding, fi, f); err != nil {
+				log.Printf("Could not compress %s: %v", name, err)
+			}
+		}
+	})
+}
+
+// HandleCompression compresses files of compressible types with brotli or gzip, if the client accepts it.
+// Compressed files are cached until they change.
+// Range requests and conditional requests apply to the compressed content,
+// its ETag is the one of the uncompressed file with the encoding appended.
+func HandleCompression(config *CompressionConfig, cache *compressionCache, etags *etagCache, fs http.FileSystem, h http.Handler) http.Handler {
+	if config == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := requestedFile(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, fi, err := openFile(fs, name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		ctype := contentType(name, f)
+		if !config.compressible(fi.Size(), ctype) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		addVary(w.Header(), "Accept-Encoding")
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+		for _, encoding := range compressionEncodings {
+			if !acceptsEncoding(acceptEncoding, encoding) {
+				continue
+			}
+			etag, err := etags.get(name, fi, f)
+			if err != nil {
+				log.Printf("Could not hash %s: %v", name, err)
+				break
+			}
+			content, err := cache.get(name, encoding, fi, f)
+			if err != nil {
+				log.Printf("Could not compress %s: %v", name, err)
+				break
+			}
+			w.Header().Set("Content-Type", ctype)
+			w.Header().Set("Content-Encoding", encoding)
+			if w.Header().Get("ETag") == "" && etag != "" {
+				w.Header().Set("ETag", strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`)
+			}
+			http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(content))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
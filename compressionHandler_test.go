@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func decompress(t *testing.T, encoding string, content []byte) string {
+	var decompressed []byte
+	var err error
+	if encoding == "br" {
+		decompressed, err = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(content)))
+	} else {
+		var r *gzip.Reader
+		r, err = gzip.NewReader(bytes.NewReader(content))
+		if err == nil {
+			decompressed, err = ioutil.ReadAll(r)
+		}
+	}
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	return string(decompressed)
+}
+
+func TestCompressionHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	css := strings.Repeat("body { color: red; }\n", 100)
+	writeFile(tempDir + "/style.css", []byte(css))
+	writeFile(tempDir + "/image.png", append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 2048)...))
+
+	config := &CompressionConfig{}
+	config.finalize()
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	cache := newCompressionCache(config.CacheSize)
+	h := HandleCompression(config, cache, newETagCache(defaultETagMaxSize), fs, http.FileServer(fs))
+
+	tests := []struct {
+		name string
+		URL string
+		acceptEncoding string
+		contentEncoding string
+	}{
+		{name: "Brotli preferred", URL: "/style.css", acceptEncoding: "gzip, br", contentEncoding: "br"},
+		{name: "Gzip", URL: "/style.css", acceptEncoding: "gzip", contentEncoding: "gzip"},
+		{name: "Identity", URL: "/style.css", acceptEncoding: "identity", contentEncoding: ""},
+		{name: "Small file", URL: "/test.txt", acceptEncoding: "gzip", contentEncoding: ""},
+		{name: "Incompressible type", URL: "/image.png", acceptEncoding: "gzip", contentEncoding: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+			}
+			if rec.Header().Get("Content-Encoding") != test.contentEncoding {
+				t.Fatalf("Expected Content-Encoding %v but got %v", test.contentEncoding, rec.Header().Get("Content-Encoding"))
+			}
+			if test.contentEncoding == "" {
+				return
+			}
+			if rec.Body.Len() >= len(css) {
+				t.Fatalf("Expected compressed body to be smaller than %v but was %v", len(css), rec.Body.Len())
+			}
+			if body := decompress(t, test.contentEncoding, rec.Body.Bytes()); body != css {
+				t.Fatalf("Expected decompressed body to match the file")
+			}
+			if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css") {
+				t.Fatalf("Expected Content-Type text/css but got %v", rec.Header().Get("Content-Type"))
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("Expected Vary: Accept-Encoding but got %v", rec.Header().Get("Vary"))
+			}
+		})
+	}
+
+	t.Run("Large file", func(t *testing.T) {
+		large := &CompressionConfig{MaxSize: int64(len(css)) - 1}
+		large.finalize()
+		h := HandleCompression(large, newCompressionCache(large.CacheSize), newETagCache(defaultETagMaxSize), fs, http.FileServer(fs))
+		r := httptest.NewRequest("GET", "/style.css", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != css {
+			t.Fatalf("Expected files larger than maxSize to be sent uncompressed")
+		}
+	})
+
+	t.Run("Cache is invalidated when the file changes", func(t *testing.T) {
+		changed := strings.Repeat("body { color: blue; }\n", 100)
+		writeFile(tempDir + "/style.css", []byte(changed))
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(tempDir + "/style.css", future, future)
+		r := httptest.NewRequest("GET", "/style.css", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if body := decompress(t, "gzip", rec.Body.Bytes()); body != changed {
+			t.Fatalf("Expected the changed file to be served")
+		}
+	})
+}
+
+func TestCompressionPrecompute(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/style.css", []byte(strings.Repeat("body { color: red; }\n", 100)))
+
+	memFS, err := newMemoryFilesystem(newDocroot(tempDir, SymlinksWithinRoot), false, false)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	config := &CompressionConfig{}
+	config.finalize()
+	cache := newCompressionCache(config.CacheSize)
+	cache.precompute(config, justFilesFilesystem{fs: memFS}, tempDir)
+	for _, key := range []string{"br:/style.css", "gzip:/style.css"} {
+		if _, ok := cache.files[key]; !ok {
+			t.Fatalf("Expected %v to be precomputed", key)
+		}
+	}
+	if _, ok := cache.files["gzip:/test.txt"]; ok {
+		t.Fatalf("Expected small files not to be precomputed")
+	}
+}
+
+// fileInfo is the os.FileInfo of a file which only exists in the test
+type fileInfo struct {
+	os.FileInfo
+	size int64
+	modTime time.Time
+}
+
+func (fi fileInfo) Size() int64 { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return fi.modTime }
+
+func TestCompressionCacheEviction(t *testing.T) {
+	content := []byte(strings.Repeat("a", 1000))
+	modTime := time.Now()
+	compressedSize := func() int64 {
+		compressed, _ := compress("gzip", content)
+		return int64(len(compressed))
+	}()
+	cache := newCompressionCache(2 * compressedSize)
+	get := func(name string) {
+		fi := fileInfo{size: int64(len(content)), modTime: modTime}
+		if _, err := cache.get(name, "gzip", fi, bytes.NewReader(content)); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	get("/a.txt")
+	get("/b.txt")
+	get("/a.txt")
+	get("/c.txt")
+	if _, ok := cache.files["gzip:/b.txt"]; ok {
+		t.Fatalf("Expected the least recently used file to be evicted")
+	}
+	for _, key := range []string{"gzip:/a.txt", "gzip:/c.txt"} {
+		if _, ok := cache.files[key]; !ok {
+			t.Fatalf("Expected %v to be cached", key)
+		}
+	}
+	if cache.size != 2 * compressedSize {
+		t.Fatalf("Expected %v cached bytes but got %v", 2 * compressedSize, cache.size)
+	}
+}
+
+// blockingReader counts the readers which have been read and blocks their reads until release is closed
+type blockingReader struct {
+	readers *int32
+	read bool
+	release chan bool
+	r io.Reader
+}
+
+func (b *blockingReader) Read(p []byte) (int, error) {
+	if !b.read {
+		b.read = true
+		atomic.AddInt32(b.readers, 1)
+	}
+	<-b.release
+	return b.r.Read(p)
+}
+
+func TestCompressionCacheDeduplicates(t *testing.T) {
+	content := []byte(strings.Repeat("a", 1000))
+	fi := fileInfo{size: int64(len(content)), modTime: time.Now()}
+	cache := newCompressionCache(0)
+	var readers int32
+	release := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			compressed, err := cache.get("/a.txt", "gzip", fi, &blockingReader{readers: &readers, release: release, r: bytes.NewReader(content)})
+			if err != nil || len(compressed) == 0 {
+				t.Errorf("Expected compressed content but got %v", err)
+			}
+		}()
+	}
+	for {
+		cache.lock.Lock()
+		pending := len(cache.pending)
+		cache.lock.Unlock()
+		if pending == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&readers); n != 1 {
+		t.Fatalf("Expected the file to be compressed once but it was read %v times", n)
+	}
+	var again int32
+	cache.get("/a.txt", "gzip", fi, &blockingReader{readers: &again, release: release, r: bytes.NewReader(content)})
+	if again != 0 {
+		t.Fatalf("Expected the cached compression to be used")
+	}
+}
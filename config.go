@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Config describes everything static-serve should serve.
+// It is either built from the command line flags or loaded from a config file (-config).
+type Config struct {
+	// Verbose activates verbose logging (e.g. when handling error 404)
+	Verbose bool `yaml:"verbose"`
+	Health HealthConfig `yaml:"health"`
+	// Connections caps the concurrent connections of all listeners
+	Connections ConnectionLimits `yaml:"connections"`
+	Sites []SiteConfig `yaml:"sites"`
+}
+
+// HealthConfig describes the listener for the /health and /ready endpoints.
+// If Port matches the port of a site, the endpoints are served by that site instead.
+type HealthConfig struct {
+	Port string `yaml:"port"`
+	TLS TLSFiles `yaml:"tls"`
+	LogAccess bool `yaml:"logAccess"`
+}
+
+// SiteConfig describes a docroot and its settings.
+// Sites sharing a port are served by a single listener and are selected by the Host header.
+type SiteConfig struct {
+	Port string `yaml:"port"`
+	// Hosts are the host names this site is served for (all hosts if empty)
+	Hosts []string `yaml:"hosts"`
+	// Default serves this site for requests which match no other site on the same port
+	Default bool `yaml:"default"`
+	// IdleTimeout after which the sites of a directory with placeholders like {1} are closed (default: 10m)
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	Directory string `yaml:"directory"`
+	FSType FSType `yaml:"fsType"`
+	// Symlinks is the symlink policy: deny, within-root (default) or follow
+	Symlinks SymlinkPolicy `yaml:"symlinks"`
+	// Error404 is the file to serve in case of error 404 (empty to disable the error404 handler)
+	Error404 string `yaml:"error404"`
+	// Error404KeepStatus sends the error 404 file with status 404 instead of 200
+	Error404KeepStatus bool `yaml:"error404KeepStatus"`
+	// ErrorPages maps status codes (404), classes (5xx) or ranges (500-503) to error pages,
+	// pages without a leading / are looked up in the directory of the request and its ancestors
+	ErrorPages map[string]string `yaml:"errorPages"`
+	errorPages *errorPages
+	// ETagMaxSize is the size in bytes up to which files get a strong ETag hashed from their content (default: 64 MiB)
+	ETagMaxSize int64 `yaml:"etagMaxSize"`
+	// Precompressed serves precompressed variants (.br, .zst, .gz) of files if the client accepts them
+	Precompressed bool `yaml:"precompressed"`
+	// Compression compresses files of compressible types which have no precompressed variants
+	Compression *CompressionConfig `yaml:"compression"`
+	// SPA serves the index of a single page application for unknown routes
+	SPA *SPAConfig `yaml:"spa"`
+	// Headers are rules which set Cache-Control, Expires and other headers on matching responses
+	Headers []HeaderRule `yaml:"headers"`
+	// SecurityHeaders adds security headers like Content-Security-Policy and HSTS to all responses
+	SecurityHeaders *SecurityHeadersConfig `yaml:"securityHeaders"`
+	// Listing lists the files of directories without an index.html instead of answering 404
+	Listing *ListingConfig `yaml:"listing"`
+	// Access hides files of the docroot, dotfiles are hidden by default
+	Access *AccessConfig `yaml:"access"`
+	// BasicAuth protects path prefixes with HTTP Basic authentication
+	BasicAuth []BasicAuthConfig `yaml:"basicAuth"`
+	// ForwardAuth serves requests only if an external authorization endpoint allows them
+	ForwardAuth *ForwardAuthConfig `yaml:"forwardAuth"`
+	// IPAccess restricts path prefixes to client addresses, the longest matching path applies
+	IPAccess []IPAccessRule `yaml:"ipAccess"`
+	// RateLimits limit the requests per second of each client to path prefixes, the longest matching path applies
+	RateLimits []RateLimitRule `yaml:"rateLimits"`
+	// TrustedProxies are the networks whose forwarded header is used to resolve the client address
+	TrustedProxies []string `yaml:"trustedProxies"`
+	trustedProxies ipList
+	// ForwardedHeader is the header the trusted proxies write the client address to,
+	// X-Forwarded-For (default) or Forwarded
+	ForwardedHeader string `yaml:"forwardedHeader"`
+	// SignedURLs requires expiring HMAC signatures for links below path prefixes
+	SignedURLs *SignedURLConfig `yaml:"signedURLs"`
+	// OIDC requires an OpenID Connect login
+	OIDC *OIDCConfig `yaml:"oidc"`
+	// CORS allows cross-origin requests from the configured origins
+	CORS *CORSConfig `yaml:"cors"`
+	TLS TLSFiles `yaml:"tls"`
+	LogAccess bool `yaml:"logAccess"`
+	LogHeaders bool `yaml:"logHeaders"`
+	// Health serves the /health and /ready endpoints on this listener
+	Health bool `yaml:"health"`
+}
+
+type TLSFiles struct {
+	Cert string `yaml:"cert"`
+	Key string `yaml:"key"`
+}
+
+func (i *FSType) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	if err := i.Set(value); err != nil {
+		return fmt.Errorf("%v: %s", err, value)
+	}
+	return nil
+}
+
+func (i *SymlinkPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	if err := i.Set(value); err != nil {
+		return fmt.Errorf("%v: %s", err, value)
+	}
+	return nil
+}
+
+func loadConfig(file string) (*Config, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(content)
+}
+
+func parseConfig(content []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.finalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// finalize applies the defaults and validates the configuration
+func (c *Config) finalize() error {
+	if len(c.Sites) == 0 {
+		return fmt.Errorf("no sites configured")
+	}
+	if (c.Health.TLS.Cert == "") != (c.Health.TLS.Key == "") {
+		return fmt.Errorf("health: both tls cert and key are required")
+	}
+	if err := c.Connections.finalize(); err != nil {
+		return fmt.Errorf("connections: %v", err)
+	}
+	for i := range c.Sites {
+		site := &c.Sites[i]
+		if site.Port == "" {
+			site.Port = "8100"
+		}
+		if site.Directory == "" {
+			site.Directory = "."
+		}
+		if site.FSType == "" {
+			site.FSType = DiskFS
+		}
+		if site.Symlinks == "" {
+			site.Symlinks = SymlinksWithinRoot
+		}
+		if site.Error404 == "-" {
+			site.Error404 = ""
+		}
+		if site.Error404 != "" && !strings.HasPrefix(site.Error404, "/") {
+			site.Error404 = "/" + site.Error404
+		}
+		var err error
+		if site.errorPages, err = parseErrorPages(site.ErrorPages); err != nil {
+			return fmt.Errorf("site %s: errorPages: %v", site.name(), err)
+		}
+		if site.Error404 != "" && site.errorPages.file(http.StatusNotFound) != "" {
+			return fmt.Errorf("site %s: error404 and an error page for 404 cannot be combined", site.name())
+		}
+		if site.ETagMaxSize == 0 {
+			site.ETagMaxSize = defaultETagMaxSize
+		}
+		if site.ETagMaxSize < 0 {
+			return fmt.Errorf("site %s: etagMaxSize must not be negative", site.name())
+		}
+		if site.Compression != nil {
+			if err := site.Compression.finalize(); err != nil {
+				return fmt.Errorf("site %s: compression: %v", site.name(), err)
+			}
+		}
+		if site.SPA != nil {
+			if err := site.SPA.finalize(); err != nil {
+				return fmt.Errorf("site %s: spa: %v", site.name(), err)
+			}
+		}
+		for j := range site.Headers {
+			if err := site.Headers[j].finalize(); err != nil {
+				return fmt.Errorf("site %s: headers[%d]: %v", site.name(), j, err)
+			}
+		}
+		if site.SecurityHeaders != nil {
+			if err := site.SecurityHeaders.finalize(); err != nil {
+				return fmt.Errorf("site %s: securityHeaders: %v", site.name(), err)
+			}
+		}
+		if site.Listing != nil {
+			if err := site.Listing.finalize(); err != nil {
+				return fmt.Errorf("site %s: listing: %v", site.name(), err)
+			}
+		}
+		if site.Access != nil {
+			if err := site.Access.finalize(); err != nil {
+				return fmt.Errorf("site %s: access: %v", site.name(), err)
+			}
+		}
+		for j := range site.BasicAuth {
+			if err := site.BasicAuth[j].finalize(); err != nil {
+				return fmt.Errorf("site %s: basicAuth: %v", site.name(), err)
+			}
+		}
+		if site.ForwardAuth != nil {
+			if err := site.ForwardAuth.finalize(); err != nil {
+				return fmt.Errorf("site %s: forwardAuth: %v", site.name(), err)
+			}
+		}
+		for j := range site.IPAccess {
+			if err := site.IPAccess[j].finalize(); err != nil {
+				return fmt.Errorf("site %s: ipAccess: %v", site.name(), err)
+			}
+		}
+		for j := range site.RateLimits {
+			if err := site.RateLimits[j].finalize(); err != nil {
+				return fmt.Errorf("site %s: rateLimits: %v", site.name(), err)
+			}
+		}
+		if site.trustedProxies, err = parseIPList(site.TrustedProxies); err != nil {
+			return fmt.Errorf("site %s: trustedProxies: %v", site.name(), err)
+		}
+		if site.ForwardedHeader == "" {
+			site.ForwardedHeader = "X-Forwarded-For"
+		}
+		site.ForwardedHeader = http.CanonicalHeaderKey(site.ForwardedHeader)
+		if !containsString(forwardedHeaders, site.ForwardedHeader) {
+			return fmt.Errorf("site %s: forwardedHeader: %s must be one of %s", site.name(), site.ForwardedHeader, strings.Join(forwardedHeaders, ", "))
+		}
+		if site.SignedURLs != nil {
+			if err := site.SignedURLs.finalize(); err != nil {
+				return fmt.Errorf("site %s: signedURLs: %v", site.name(), err)
+			}
+		}
+		if site.OIDC != nil {
+			if err := site.OIDC.finalize(); err != nil {
+				return fmt.Errorf("site %s: oidc: %v", site.name(), err)
+			}
+		}
+		if site.CORS != nil {
+			if err := site.CORS.finalize(); err != nil {
+				return fmt.Errorf("site %s: cors: %v", site.name(), err)
+			}
+		}
+		for j, host := range site.Hosts {
+			site.Hosts[j] = normalizeHost(host)
+			if err := site.validateHost(site.Hosts[j]); err != nil {
+				return fmt.Errorf("site %s: %v", site.name(), err)
+			}
+		}
+		if site.isDynamic() {
+			if site.isDefault() {
+				return fmt.Errorf("site %s: a directory with placeholders requires host patterns and cannot be the default site", site.name())
+			}
+			if site.IdleTimeout == 0 {
+				site.IdleTimeout = 10 * time.Minute
+			}
+		}
+		if (site.TLS.Cert == "") != (site.TLS.Key == "") {
+			return fmt.Errorf("site %s: both tls cert and key are required", site.name())
+		}
+		if c.Health.Port != "" && site.Port == c.Health.Port {
+			site.Health = true
+		}
+	}
+	for _, port := range c.ports() {
+		if err := c.validatePort(port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePort checks that the sites sharing a port can be told apart by their hosts
+func (c *Config) validatePort(port string) error {
+	hosts := map[string]bool{}
+	defaults := 0
+	tlsSites := 0
+	sites := 0
+	for _, site := range c.Sites {
+		if site.Port != port {
+			continue
+		}
+		sites++
+		if site.isDefault() {
+			defaults++
+		}
+		if site.TLS.Cert != "" {
+			tlsSites++
+		}
+		for _, host := range site.Hosts {
+			if hosts[host] {
+				return fmt.Errorf("host %s is configured for multiple sites on port %s", host, port)
+			}
+			hosts[host] = true
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("port %s is configured for multiple sites without distinct hosts", port)
+	}
+	if tlsSites != 0 && tlsSites != sites {
+		return fmt.Errorf("port %s mixes sites with and without tls", port)
+	}
+	return nil
+}
+
+// ports returns the distinct ports of all sites in the order of their first appearance
+func (c *Config) ports() []string {
+	var ports []string
+	seen := map[string]bool{}
+	for _, site := range c.Sites {
+		if !seen[site.Port] {
+			seen[site.Port] = true
+			ports = append(ports, site.Port)
+		}
+	}
+	return ports
+}
+
+// isDefault returns whether the site serves the requests of unknown hosts
+func (s *SiteConfig) isDefault() bool {
+	return s.Default || len(s.Hosts) == 0
+}
+
+// isDynamic returns whether the directory depends on the host (e.g. /srv/previews/{1})
+func (s *SiteConfig) isDynamic() bool {
+	return isDynamicDirectory(s.Directory)
+}
+
+func (s *SiteConfig) validateHost(host string) error {
+	if !isHostPattern(host) {
+		if s.isDynamic() {
+			return fmt.Errorf("host %s of a directory with placeholders must be a pattern", host)
+		}
+		return nil
+	}
+	pattern, err := parseHostPattern(host)
+	if err != nil {
+		return err
+	}
+	if s.isDynamic() && pattern.wildcards() < maxPlaceholder(s.Directory) {
+		return fmt.Errorf("host pattern %s has less wildcards than the placeholders of %s", host, s.Directory)
+	}
+	return nil
+}
+
+// name identifies the site in error messages
+func (s *SiteConfig) name() string {
+	if len(s.Hosts) > 0 {
+		return s.Hosts[0] + ":" + s.Port
+	}
+	return "on port " + s.Port
+}
+
+// servesHealthPort returns whether the health port is served by one of the sites
+func (c *Config) servesHealthPort() bool {
+	for _, site := range c.Sites {
+		if site.Port == c.Health.Port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+verbose: true
+health:
+  port: "8081"
+sites:
+  - port: "8100"
+    directory: /srv/docs
+    fsType: INMEM
+    error404: 404.html
+    logAccess: true
+    listing: true
+  - port: "8081"
+    tls:
+      cert: cert.pem
+      key: key.pem
+`))
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	if !cfg.Verbose {
+		t.Fatalf("Expected verbose to be set")
+	}
+	if len(cfg.Sites) != 2 {
+		t.Fatalf("Expected %v sites but got %v", 2, len(cfg.Sites))
+	}
+	docs := cfg.Sites[0]
+	if docs.Directory != "/srv/docs" || docs.FSType != INMem || docs.Error404 != "/404.html" || !docs.LogAccess || docs.Health {
+		t.Fatalf("Unexpected first site %+v", docs)
+	}
+	if !docs.Listing.lists("/any/") {
+		t.Fatalf("Expected all directories to be listed")
+	}
+	app := cfg.Sites[1]
+	if app.Directory != "." || app.FSType != DiskFS || app.Error404 != "" || app.TLS.Cert != "cert.pem" || !app.Health || app.Listing != nil {
+		t.Fatalf("Unexpected second site %+v", app)
+	}
+	if !cfg.servesHealthPort() {
+		t.Fatalf("Expected health port to be served by a site")
+	}
+}
+
+func TestParseConfigVirtualHosts(t *testing.T) {
+	cfg, err := parseConfig([]byte(`
+sites:
+  - port: "8100"
+    hosts: [Docs.Example.com]
+    directory: /srv/docs
+  - port: "8100"
+    hosts: [app.example.com, www.example.com]
+    default: true
+    directory: /srv/app
+  - port: "8200"
+`))
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	ports := cfg.ports()
+	if len(ports) != 2 || ports[0] != "8100" || ports[1] != "8200" {
+		t.Fatalf("Unexpected ports %v", ports)
+	}
+	if cfg.Sites[0].Hosts[0] != "docs.example.com" {
+		t.Fatalf("Expected normalized host but got %v", cfg.Sites[0].Hosts[0])
+	}
+	if cfg.Sites[0].isDefault() || !cfg.Sites[1].isDefault() || !cfg.Sites[2].isDefault() {
+		t.Fatalf("Unexpected default sites %+v", cfg.Sites)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		config string
+		shouldContain string
+	}{
+		{
+			name: "No sites",
+			config: `verbose: true`,
+			shouldContain: "no sites configured",
+		},
+		{
+			name: "Invalid fs type",
+			config: "sites:\n  - fsType: tmpfs",
+			shouldContain: "Invalid filesystem type",
+		},
+		{
+			name: "Unknown field",
+			config: "sites:\n  - prot: \"8100\"",
+			shouldContain: "prot",
+		},
+		{
+			name: "Duplicate port",
+			config: "sites:\n  - port: \"8100\"\n  - directory: /srv",
+			shouldContain: "port 8100 is configured for multiple sites",
+		},
+		{
+			name: "Duplicate host",
+			config: "sites:\n  - hosts: [a.example.com]\n  - hosts: [A.example.com]",
+			shouldContain: "host a.example.com is configured for multiple sites on port 8100",
+		},
+		{
+			name: "Multiple defaults",
+			config: "sites:\n  - hosts: [a.example.com]\n    default: true\n  - directory: /srv",
+			shouldContain: "port 8100 is configured for multiple sites without distinct hosts",
+		},
+		{
+			name: "Mixed TLS",
+			config: "sites:\n  - hosts: [a.example.com]\n    tls: {cert: cert.pem, key: key.pem}\n  - directory: /srv",
+			shouldContain: "port 8100 mixes sites with and without tls",
+		},
+		{
+			name: "Dynamic directory without host pattern",
+			config: "sites:\n  - hosts: [preview.example.com]\n    directory: /srv/previews/{1}",
+			shouldContain: "must be a pattern",
+		},
+		{
+			name: "Dynamic directory with too few wildcards",
+			config: "sites:\n  - hosts: [\"*.preview.example.com\"]\n    directory: /srv/{2}/{1}",
+			shouldContain: "less wildcards than the placeholders",
+		},
+		{
+			name: "Invalid error page status",
+			config: "sites:\n  - errorPages:\n      \"200\": ok.html",
+			shouldContain: "invalid error status 200",
+		},
+		{
+			name: "Error 404 file and error page",
+			config: "sites:\n  - error404: /index.html\n    errorPages:\n      4xx: 4xx.html",
+			shouldContain: "error404 and an error page for 404 cannot be combined",
+		},
+		{
+			name: "Invalid header rule regex",
+			config: "sites:\n  - headers:\n      - regex: \"[a\"\n        cacheControl: no-cache",
+			shouldContain: "headers[0]: invalid regex",
+		},
+		{
+			name: "Header rule without headers",
+			config: "sites:\n  - headers:\n      - path: \"*.html\"",
+			shouldContain: "rule sets no headers",
+		},
+		{
+			name: "Unknown security headers preset",
+			config: "sites:\n  - securityHeaders:\n      preset: paranoid",
+			shouldContain: "unknown preset paranoid, expected one of basic, strict",
+		},
+		{
+			name: "CORS without origins",
+			config: "sites:\n  - cors:\n      credentials: true",
+			shouldContain: "cors: no origins configured",
+		},
+		{
+			name: "Disabled listing",
+			config: "sites:\n  - listing: false",
+			shouldContain: "listing must be true or a mapping",
+		},
+		{
+			name: "Invalid listing sort",
+			config: "sites:\n  - listing:\n      sort: owner",
+			shouldContain: "listing: invalid sort owner",
+		},
+		{
+			name: "Basic authentication without htpasswd",
+			config: "sites:\n  - basicAuth:\n      - path: /staging",
+			shouldContain: "basicAuth: no htpasswd file configured for /staging",
+		},
+		{
+			name: "Relative forward auth url",
+			config: "sites:\n  - forwardAuth:\n      url: /verify",
+			shouldContain: "forwardAuth: url /verify must be an absolute http or https url",
+		},
+		{
+			name: "Short OIDC cookie secret",
+			config: "sites:\n  - oidc:\n      issuer: https://sso.example.com\n      clientID: docs\n      redirectURL: https://docs.example.com/oauth2/callback\n      cookieSecret: short",
+			shouldContain: "oidc: cookieSecret must be at least 32 characters long",
+		},
+		{
+			name: "Short signing secret",
+			config: "sites:\n  - signedURLs:\n      paths: [/reports]\n      secrets: [secret]",
+			shouldContain: "signedURLs: secrets must be at least 16 characters long",
+		},
+		{
+			name: "Invalid ip access network",
+			config: "sites:\n  - ipAccess:\n      - allow: [10.0.0.0/33]",
+			shouldContain: "ipAccess: invalid network 10.0.0.0/33",
+		},
+		{
+			name: "Invalid trusted proxy",
+			config: "sites:\n  - trustedProxies: [proxy.example.com]",
+			shouldContain: "trustedProxies: invalid address proxy.example.com",
+		},
+		{
+			name: "Rate limit without rate",
+			config: "sites:\n  - rateLimits:\n      - path: /mirror\n        burst: 5",
+			shouldContain: "rateLimits: rate of /mirror must be positive",
+		},
+		{
+			name: "Negative connection limit",
+			config: "connections:\n  perIP: -1\nsites:\n  - port: \"8100\"",
+			shouldContain: "connections: limits must not be negative",
+		},
+		{
+			name: "Negative connection timeout",
+			config: "connections:\n  idleTimeout: -1s\nsites:\n  - port: \"8100\"",
+			shouldContain: "connections: timeouts must not be negative",
+		},
+		{
+			name: "Unknown forwarded header",
+			config: "sites:\n  - trustedProxies: [10.0.0.0/8]\n    forwardedHeader: X-Real-IP",
+			shouldContain: "forwardedHeader: X-Real-Ip must be one of X-Forwarded-For, Forwarded",
+		},
+		{
+			name: "Compression max size below min size",
+			config: "sites:\n  - compression:\n      minSize: 2048\n      maxSize: 1024",
+			shouldContain: "compression: maxSize 1024 must not be smaller than minSize 2048",
+		},
+		{
+			name: "Negative ETag max size",
+			config: "sites:\n  - etagMaxSize: -1",
+			shouldContain: "etagMaxSize must not be negative",
+		},
+		{
+			name: "Incomplete TLS",
+			config: "sites:\n  - tls:\n      cert: cert.pem",
+			shouldContain: "both tls cert and key are required",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseConfig([]byte(test.config))
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			if !strings.Contains(err.Error(), test.shouldContain) {
+				t.Fatalf("%v should contain %v", err, test.shouldContain)
+			}
+		})
+	}
+}
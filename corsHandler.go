@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig allows cross-origin requests from the configured origins
+type CORSConfig struct {
+	// Origins are exact origins like https://app.example.com, wildcards like https://*.example.com or * for all origins
+	Origins []string `yaml:"origins"`
+	// OriginRegexes are regular expressions matched against the whole origin
+	OriginRegexes []string `yaml:"originRegexes"`
+	// Methods are the allowed methods (default: GET, HEAD)
+	Methods []string `yaml:"methods"`
+	// Headers are the allowed request headers, * allows all
+	Headers []string `yaml:"headers"`
+	// ExposeHeaders are the response headers which scripts may read
+	ExposeHeaders []string `yaml:"exposeHeaders"`
+	// Credentials allows cookies and authorization headers
+	Credentials bool `yaml:"credentials"`
+	// MaxAge is how long the result of a preflight may be cached
+	MaxAge time.Duration `yaml:"maxAge"`
+	anyOrigin bool
+	exact map[string]bool
+	patterns []*regexp.Regexp
+}
+
+func (c *CORSConfig) finalize() error {
+	if len(c.Origins) == 0 && len(c.OriginRegexes) == 0 {
+		return fmt.Errorf("no origins configured")
+	}
+	c.exact = map[string]bool{}
+	c.patterns = nil
+	for _, origin := range c.Origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		if origin == "*" {
+			c.anyOrigin = true
+		} else if strings.Contains(origin, "*") {
+			// a wildcard matches one or more labels of the host name
+			expr := strings.Replace(regexp.QuoteMeta(origin), `\*`, `[a-z0-9-]+(\.[a-z0-9-]+)*`, -1)
+			c.patterns = append(c.patterns, regexp.MustCompile("^" + expr + "$"))
+		} else {
+			c.exact[origin] = true
+		}
+	}
+	for _, expr := range c.OriginRegexes {
+		pattern, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid origin regex %s: %v", expr, err)
+		}
+		c.patterns = append(c.patterns, pattern)
+	}
+	if len(c.Methods) == 0 {
+		c.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+	for i, method := range c.Methods {
+		c.Methods[i] = strings.ToUpper(method)
+	}
+	return nil
+}
+
+func (c *CORSConfig) allowsOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.exact[origin] {
+		return true
+	}
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORSConfig) allowsMethod(method string) bool {
+	for _, m := range c.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORSConfig) allowsHeaders(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !containsFold(c.Headers, header) && !containsFold(c.Headers, "*") {
+			return false
+		}
+	}
+	return true
+}
+
+// setAllowOrigin sets the allowed origin, the origin is reflected unless all origins are allowed without credentials
+func (c *CORSConfig) setAllowOrigin(header http.Header, origin string) {
+	if c.anyOrigin && !c.Credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// HandleCORS adds the CORS headers to responses for allowed origins and answers preflight requests.
+// Vary: Origin is added to all responses, unless all origins are allowed without credentials,
+// so that caches don't mix responses for different origins.
+func HandleCORS(cors *CORSConfig, h http.Handler) http.Handler {
+	if cors == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		if !cors.anyOrigin || cors.Credentials {
+			addVary(header, "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && origin != "" && requestMethod != "" {
+			addVary(header, "Access-Control-Request-Method")
+			addVary(header, "Access-Control-Request-Headers")
+			requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if cors.allowsOrigin(origin) && cors.allowsMethod(requestMethod) && cors.allowsHeaders(requestHeaders) {
+				cors.setAllowOrigin(header, origin)
+				header.Set("Access-Control-Allow-Methods", strings.Join(cors.Methods, ", "))
+				if requestHeaders != "" {
+					header.Set("Access-Control-Allow-Headers", requestHeaders)
+				}
+				if cors.MaxAge > 0 {
+					header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if origin != "" && cors.allowsOrigin(origin) {
+			cors.setAllowOrigin(header, origin)
+			if len(cors.ExposeHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposeHeaders, ", "))
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
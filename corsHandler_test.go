@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSHandler(t *testing.T) {
+	cors := &CORSConfig{
+		Origins: []string{"https://app.example.com", "https://*.partner.com"},
+		OriginRegexes: []string{`https://preview-\d+\.example\.org`},
+		Methods: []string{"get", "head", "post"},
+		Headers: []string{"Content-Type", "X-Requested-With"},
+		ExposeHeaders: []string{"ETag"},
+		Credentials: true,
+		MaxAge: 10 * time.Minute,
+	}
+	if err := cors.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	h := HandleCORS(cors, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}))
+
+	tests := []struct {
+		name string
+		method string
+		header map[string]string
+		code int
+		expected map[string]string
+	}{
+		{name: "Exact origin", method: "GET", header: map[string]string{"Origin": "https://app.example.com"}, code: http.StatusOK,
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com", "Access-Control-Allow-Credentials": "true", "Access-Control-Expose-Headers": "ETag", "Vary": "Origin"}},
+		{name: "Wildcard origin", method: "GET", header: map[string]string{"Origin": "https://fonts.eu.partner.com"}, code: http.StatusOK,
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://fonts.eu.partner.com"}},
+		{name: "Wildcard does not match other domains", method: "GET", header: map[string]string{"Origin": "https://evil.com/.partner.com"}, code: http.StatusOK,
+			expected: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "Regex origin", method: "GET", header: map[string]string{"Origin": "https://preview-42.example.org"}, code: http.StatusOK,
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://preview-42.example.org"}},
+		{name: "Unknown origin", method: "GET", header: map[string]string{"Origin": "https://evil.com"}, code: http.StatusOK,
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"}},
+		{name: "Preflight", method: "OPTIONS", header: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type"}, code: http.StatusNoContent,
+			expected: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com", "Access-Control-Allow-Methods": "GET, HEAD, POST", "Access-Control-Allow-Headers": "content-type", "Access-Control-Max-Age": "600"}},
+		{name: "Preflight with disallowed method", method: "OPTIONS", header: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"}, code: http.StatusNoContent,
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""}},
+		{name: "Preflight with disallowed header", method: "OPTIONS", header: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "Authorization"}, code: http.StatusNoContent,
+			expected: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{name: "OPTIONS without preflight", method: "OPTIONS", header: map[string]string{}, code: http.StatusOK,
+			expected: map[string]string{"Access-Control-Allow-Origin": ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/data.json", nil)
+			for name, value := range test.header {
+				r.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			for name, value := range test.expected {
+				if rec.Header().Get(name) != value {
+					t.Fatalf("Expected %s %v but got %v", name, value, rec.Header().Get(name))
+				}
+			}
+		})
+	}
+
+	t.Run("Any origin", func(t *testing.T) {
+		wildcard := &CORSConfig{Origins: []string{"*"}}
+		if err := wildcard.finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		r := httptest.NewRequest("GET", "/font.woff2", nil)
+		r.Header.Set("Origin", "https://anywhere.com")
+		rec := httptest.NewRecorder()
+		HandleCORS(wildcard, http.NotFoundHandler()).ServeHTTP(rec, r)
+		if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Fatalf("Expected %v but got %v", "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if rec.Header().Get("Vary") != "" {
+			t.Fatalf("Expected no Vary but got %v", rec.Header().Get("Vary"))
+		}
+	})
+}
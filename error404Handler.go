@@ -11,7 +11,9 @@ import (
 
 var ignoreError404 = errors.New("ignored file")
 
-func HandleError404(error404File *string, shouldLog bool, h http.Handler) http.Handler {
+// HandleError404 serves the error404File instead of the 404 response of h.
+// With keepStatus the error file is sent with status 404, otherwise with the status of the error file (e.g. 200).
+func HandleError404(error404File *string, keepStatus bool, shouldLog bool, h http.Handler) http.Handler {
 	if error404File == nil || *error404File == "" {
 		return h
 	}
@@ -41,7 +43,7 @@ func HandleError404(error404File *string, shouldLog bool, h http.Handler) http.H
 					}
 				},
 			}
-			originalHeader http.Header
+			originalHeader = http.Header{}
 		)
 		wrapped := httpsnoop.Wrap(w, hooks)
 		CopyHeaders(originalHeader, w.Header())
@@ -57,11 +59,53 @@ func HandleError404(error404File *string, shouldLog bool, h http.Handler) http.H
 			*r2.URL = *r.URL
 			r2.URL.Path = *error404File
 			r2.RequestURI = r2.URL.RequestURI()
-			h.ServeHTTP(w, r2)
+			if !keepStatus {
+				h.ServeHTTP(w, r2)
+				return
+			}
+			// the error file must be sent completely, it's not the resource the validators were sent for
+			r2.Header = r.Header.Clone()
+			for _, header := range conditionalHeaders {
+				r2.Header.Del(header)
+			}
+			h.ServeHTTP(httpsnoop.Wrap(w, keepStatusHooks(w, http.StatusNotFound)), r2)
 		}
 	})
 }
 
+var conditionalHeaders = []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range", "Range"}
+
+// keepStatusHooks replace a successful status by the given status and remove the cache validators,
+// which belong to the file served instead of the requested one.
+func keepStatusHooks(w http.ResponseWriter, status int) httpsnoop.Hooks {
+	wroteHeader := false
+	writeHeader := func(next httpsnoop.WriteHeaderFunc, code int) {
+		wroteHeader = true
+		if code == http.StatusOK {
+			code = status
+			w.Header().Del("Last-Modified")
+			w.Header().Del("ETag")
+			w.Header().Del("Accept-Ranges")
+		}
+		next(code)
+	}
+	return httpsnoop.Hooks{
+		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+			return func(code int) {
+				writeHeader(next, code)
+			}
+		},
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(p []byte) (int, error) {
+				if !wroteHeader {
+					writeHeader(w.WriteHeader, http.StatusOK)
+				}
+				return next(p)
+			}
+		},
+	}
+}
+
 // CopyHeaders copies http headers from source to destination, it
 // does not override, but adds multiple headers
 func CopyHeaders(dst http.Header, src http.Header) {
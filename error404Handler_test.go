@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -14,7 +15,7 @@ func TestError404HandlerDisabled(t *testing.T) {
 	writeFile(tempDir + "/index.html", []byte("<html><body>Foo bar</body></html>"))
 
 	error404File := ""
-	h := HandleError404(&error404File, true, http.FileServer(justFilesFilesystem{http.Dir(tempDir)}))
+	h := HandleError404(&error404File, false, true, http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
 
 	tests := []loggerTest{
 		{
@@ -82,7 +83,7 @@ func TestError404HandlerNoLogging(t *testing.T) {
 	writeFile(tempDir + "/index.html", []byte("<html><body>Foo bar</body></html>"))
 
 	error404File := "/"
-	h := HandleError404(&error404File, false, http.FileServer(justFilesFilesystem{http.Dir(tempDir)}))
+	h := HandleError404(&error404File, false, false, http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
 
 	tests := []loggerTest{
 		{
@@ -158,7 +159,7 @@ func TestError404HandlerWithLogging(t *testing.T) {
 	writeFile(tempDir + "/index.html", []byte("<html><body>Foo bar</body></html>"))
 
 	error404File := "/"
-	h := HandleError404(&error404File, true, http.FileServer(justFilesFilesystem{http.Dir(tempDir)}))
+	h := HandleError404(&error404File, false, true, http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
 
 	tests := []loggerTest{
 		{
@@ -232,3 +233,74 @@ func TestError404HandlerWithLogging(t *testing.T) {
 
 	runLoggerTests(t, h, tests)
 }
+
+func TestError404HandlerKeepStatus(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/404.html", []byte("<html><body>Not here</body></html>"))
+
+	error404File := "404.html"
+	h := HandleError404(&error404File, true, false, http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
+
+	tests := []loggerTest{
+		{
+			name: "File serving should still work as expected",
+			method: "GET",
+			URL: "/test.txt",
+			tests: func (t *testing.T, logs *bytes.Buffer, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+				}
+				if rec.Header().Get("Last-Modified") == "" {
+					t.Fatalf("Expected Last-Modified header for existing file")
+				}
+			},
+		},
+		{
+			name: "Serve error page with status 404",
+			method: "GET",
+			URL: "/missing.txt",
+			tests: func (t *testing.T, logs *bytes.Buffer, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusNotFound {
+					t.Fatalf("Expected %v but got %v", http.StatusNotFound, rec.Code)
+				}
+				shouldContain := "Not here"
+				body := string(rec.Body.Bytes())
+				if !strings.Contains(body, shouldContain) {
+					t.Fatalf("%v should contain %v", body, shouldContain)
+				}
+				mimeText := "text/html; charset=utf-8"
+				contentType := rec.Header().Get("Content-type")
+				if contentType != mimeText {
+					t.Fatalf("Expected mime type %v but got %v", mimeText, contentType)
+				}
+				contentLength := rec.Header().Get("Content-Length")
+				if contentLength != strconv.Itoa(len(body)) {
+					t.Fatalf("Expected Content-Length %v but got %v", len(body), contentLength)
+				}
+				for _, header := range []string{"Last-Modified", "ETag", "Accept-Ranges", "X-Content-Type-Options"} {
+					if value := rec.Header().Get(header); value != "" {
+						t.Fatalf("Expected no %v header but got %v", header, value)
+					}
+				}
+			},
+		},
+	}
+
+	runLoggerTests(t, h, tests)
+
+	t.Run("Conditional request still returns the error page", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/missing.txt", nil)
+		r.Header.Set("If-Modified-Since", "Mon, 02 Jan 2040 15:04:05 GMT")
+		r.Header.Set("Range", "bytes=0-3")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("Expected %v but got %v", http.StatusNotFound, rec.Code)
+		}
+		shouldContain := "Not here"
+		if !strings.Contains(rec.Body.String(), shouldContain) {
+			t.Fatalf("%v should contain %v", rec.Body.String(), shouldContain)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/felixge/httpsnoop"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ignoredErrorBody = errors.New("ignored error body")
+
+type statusRange struct {
+	from int
+	to int
+	file string
+}
+
+// errorPages maps status codes to error page files.
+// Files without a leading / are looked up in the directory of the request and its ancestors,
+// e.g. /docs/404.html is used for misses under /docs/ and /404.html for all others.
+// Files ending with .tmpl are rendered as html/template with errorPageData.
+type errorPages struct {
+	exact map[int]string
+	// ranges are sorted by their width, so that the most specific range wins
+	ranges []statusRange
+}
+
+// parseErrorPages parses a mapping like {"404": "404.html", "5xx": "/errors/50x.html", "500-503": "500.html"}
+func parseErrorPages(mapping map[string]string) (*errorPages, error) {
+	if len(mapping) == 0 {
+		return nil, nil
+	}
+	pages := &errorPages{exact: map[int]string{}}
+	for codes, file := range mapping {
+		if file == "" {
+			return nil, fmt.Errorf("no error page configured for %s", codes)
+		}
+		codes = strings.ToLower(strings.TrimSpace(codes))
+		var from, to int
+		var err error
+		if strings.HasSuffix(codes, "xx") && len(codes) == 3 {
+			from, err = strconv.Atoi(codes[:1])
+			from, to = from*100, from*100+99
+		} else if i := strings.Index(codes, "-"); i > 0 {
+			from, err = strconv.Atoi(codes[:i])
+			if err == nil {
+				to, err = strconv.Atoi(codes[i+1:])
+			}
+		} else {
+			from, err = strconv.Atoi(codes)
+			to = from
+		}
+		if err != nil || from < 400 || to > 599 || from > to {
+			return nil, fmt.Errorf("invalid error status %s, expected a code (404), a class (5xx) or a range (500-503) between 400 and 599", codes)
+		}
+		if from == to {
+			pages.exact[from] = file
+		} else {
+			pages.ranges = append(pages.ranges, statusRange{from, to, file})
+		}
+	}
+	sort.SliceStable(pages.ranges, func(i, j int) bool {
+		return pages.ranges[i].to - pages.ranges[i].from < pages.ranges[j].to - pages.ranges[j].from
+	})
+	return pages, nil
+}
+
+// file returns the error page configured for the status, empty if there is none
+func (p *errorPages) file(status int) string {
+	if p == nil {
+		return ""
+	}
+	if file, ok := p.exact[status]; ok {
+		return file
+	}
+	for _, r := range p.ranges {
+		if status >= r.from && status <= r.to {
+			return r.file
+		}
+	}
+	return ""
+}
+
+// lookup returns the path of the error page for the request path, empty if there is none
+func (p *errorPages) lookup(fs http.FileSystem, status int, requestPath string) string {
+	file := p.file(status)
+	if file == "" {
+		return ""
+	}
+	if strings.HasPrefix(file, "/") {
+		if isFile(fs, file) {
+			return file
+		}
+		return ""
+	}
+	dir := requestPath
+	if !strings.HasSuffix(dir, "/") {
+		dir = path.Dir(dir)
+	}
+	for {
+		candidate := path.Join(dir, file)
+		if isFile(fs, candidate) {
+			return candidate
+		}
+		if dir == "/" || dir == "." || dir == "" {
+			return ""
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func isFile(fs http.FileSystem, name string) bool {
+	f, err := fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	return err == nil && !fi.IsDir()
+}
+
+// HandleErrorPages replaces the body of error responses by the error page configured for their status.
+// The status code and headers like Allow or Retry-After are kept, cache validators are removed.
+// Responses for which no error page exists are passed through.
+func HandleErrorPages(pages *errorPages, fs http.FileSystem, shouldLog bool, h http.Handler) http.Handler {
+	if pages == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveWithErrorPages(pages, fs, shouldLog, r.URL.Path, nil, h, w, r)
+	})
+}
+
+const allowedKey = contextKey("allowed")
+
+// HandleAccessErrorPages serves error pages for the responses of the access control handlers which accessControl
+// wraps around h, e.g. 401, 403 and 429. Responses of allowed requests are left to the HandleErrorPages of h.
+// Relative error pages are looked up from the docroot, so files of protected directories aren't shown to denied clients.
+func HandleAccessErrorPages(pages *errorPages, fs http.FileSystem, shouldLog bool, accessControl func(http.Handler) http.Handler, h http.Handler) http.Handler {
+	if pages == nil {
+		return accessControl(h)
+	}
+	checked := accessControl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed, ok := r.Context().Value(allowedKey).(*bool); ok {
+			*allowed = true
+		}
+		h.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := false
+		r = r.WithContext(context.WithValue(r.Context(), allowedKey, &allowed))
+		serveWithErrorPages(pages, fs, shouldLog, "/", func() bool { return allowed }, checked, w, r)
+	})
+}
+
+// serveWithErrorPages serves the request with h and replaces error responses by the error page found from lookupPath,
+// unless skip returns true when the status is written
+func serveWithErrorPages(pages *errorPages, fs http.FileSystem, shouldLog bool, lookupPath string, skip func() bool, h http.Handler, w http.ResponseWriter, r *http.Request) {
+	var (
+		status = 0
+		errorPage = ""
+		hooks = httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if status != 0 {
+						return
+					}
+					status = code
+					if code >= 400 && (skip == nil || !skip()) {
+						errorPage = pages.lookup(fs, code, lookupPath)
+					}
+					if errorPage == "" {
+						next(code)
+					}
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(p []byte) (int, error) {
+					if errorPage != "" {
+						return 0, ignoredErrorBody
+					}
+					return next(p)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					if errorPage != "" {
+						return 0, ignoredErrorBody
+					}
+					return next(src)
+				}
+			},
+		}
+	)
+	h.ServeHTTP(httpsnoop.Wrap(w, hooks), r)
+	if errorPage == "" {
+		return
+	}
+	if shouldLog {
+		log.Printf("Serving %s for status %d of %s", errorPage, status, r.URL.Path)
+	}
+	serveErrorPage(w, r, fs, errorPage, status)
+}
+
+func serveErrorPage(w http.ResponseWriter, r *http.Request, fs http.FileSystem, errorPage string, status int) {
+	f, err := fs.Open(errorPage)
+	if err != nil {
+		log.Printf("Could not open error page %s: %v", errorPage, err)
+		w.WriteHeader(status)
+		return
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		log.Printf("Could not read error page %s: %v", errorPage, err)
+		w.WriteHeader(status)
+		return
+	}
+	header := w.Header()
+	for _, h := range []string{"Content-Encoding", "ETag", "Last-Modified", "Accept-Ranges"} {
+		header.Del(h)
+	}
+	if status != http.StatusRequestedRangeNotSatisfiable {
+		// Content-Range of a 416 tells the client the size of the requested resource
+		header.Del("Content-Range")
+	}
+	name := errorPage
+	if path.Ext(errorPage) == templateExt {
+		name = strings.TrimSuffix(errorPage, templateExt)
+		content, err = renderErrorPage(errorPage, content, r, status)
+		if err != nil {
+			log.Printf("Could not render error page %s: %v", errorPage, err)
+			header.Del("Content-Length")
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(content)
+	}
+}
+
+// templateExt marks error pages which are rendered as html/template, e.g. 404.html.tmpl
+const templateExt = ".tmpl"
+
+// errorPageData is passed to error page templates
+type errorPageData struct {
+	Path string
+	Status int
+	StatusText string
+	// RequestID is the id under which the request was logged (-r), empty if it wasn't logged
+	RequestID string
+	Time time.Time
+}
+
+func renderErrorPage(name string, content []byte, r *http.Request, status int) ([]byte, error) {
+	tmpl, err := template.New(name).Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, errorPageData{
+		Path: r.URL.Path,
+		Status: status,
+		StatusText: http.StatusText(status),
+		RequestID: RequestID(r),
+		Time: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
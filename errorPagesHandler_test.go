@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorPages(t *testing.T) {
+	pages, err := parseErrorPages(map[string]string{
+		"404": "404.html",
+		"4xx": "4xx.html",
+		"500-503": "50x.html",
+		"5XX": "/5xx.html",
+	})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	expected := map[int]string{404: "404.html", 403: "4xx.html", 416: "4xx.html", 500: "50x.html", 503: "50x.html", 504: "/5xx.html", 302: ""}
+	for status, file := range expected {
+		if pages.file(status) != file {
+			t.Fatalf("Expected %v for %v but got %v", file, status, pages.file(status))
+		}
+	}
+	for _, codes := range []string{"200", "6xx", "503-500", "abc"} {
+		if _, err := parseErrorPages(map[string]string{codes: "error.html"}); err == nil {
+			t.Fatalf("Expected an error for %v", codes)
+		}
+	}
+}
+
+func TestErrorPagesHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.MkdirAll(tempDir + "/docs/api", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/404.html", []byte("<html><body>Site not found</body></html>"))
+	writeFile(tempDir + "/docs/404.html", []byte("<html><body>Docs not found</body></html>"))
+	writeFile(tempDir + "/docs/api/index.txt", []byte("api"))
+	writeFile(tempDir + "/50x.html", []byte("<html><body>Unavailable</body></html>"))
+
+	pages, err := parseErrorPages(map[string]string{"404": "404.html", "5xx": "/50x.html"})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	files := http.FileServer(fs)
+	unavailable := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/maintenance" {
+			w.Header().Set("Retry-After", "120")
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/forbidden" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+	h := HandleErrorPages(pages, fs, false, unavailable)
+
+	tests := []struct {
+		name string
+		URL string
+		code int
+		shouldContain string
+		header string
+		headerValue string
+	}{
+		{name: "Existing file", URL: "/test.txt", code: http.StatusOK, shouldContain: "hello go"},
+		{name: "Root error page", URL: "/missing.html", code: http.StatusNotFound, shouldContain: "Site not found", header: "Content-Type", headerValue: "text/html; charset=utf-8"},
+		{name: "Nearest ancestor error page", URL: "/docs/missing.html", code: http.StatusNotFound, shouldContain: "Docs not found"},
+		{name: "Nearest ancestor error page of sub directory", URL: "/docs/api/missing.html", code: http.StatusNotFound, shouldContain: "Docs not found"},
+		{name: "Absolute error page", URL: "/maintenance", code: http.StatusServiceUnavailable, shouldContain: "Unavailable", header: "Retry-After", headerValue: "120"},
+		{name: "Status without error page", URL: "/forbidden", code: http.StatusForbidden, shouldContain: "forbidden"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, test.shouldContain) {
+				t.Fatalf("%v should contain %v", body, test.shouldContain)
+			}
+			if test.header != "" && rec.Header().Get(test.header) != test.headerValue {
+				t.Fatalf("Expected %v header %v but got %v", test.header, test.headerValue, rec.Header().Get(test.header))
+			}
+		})
+	}
+}
+
+func TestErrorPagesTemplate(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/404.html.tmpl", []byte("<p>{{.Status}} {{.StatusText}}: {{.Path}}</p><p>Request {{.RequestID}} at {{.Time.Year}}</p>"))
+	writeFile(tempDir + "/500.html.tmpl", []byte("<p>{{.Unknown}}</p>"))
+
+	pages, err := parseErrorPages(map[string]string{"404": "404.html.tmpl", "500": "/500.html.tmpl"})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	files := http.FileServer(fs)
+	failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+	h := LogReqResponse(true, ":8100", HandleErrorPages(pages, fs, false, failing))
+
+	tests := []loggerTest{
+		{
+			name: "Rendered error page",
+			method: "GET",
+			URL: "/<script>alert(1)</script>",
+			tests: func (t *testing.T, logs *bytes.Buffer, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusNotFound {
+					t.Fatalf("Expected %v but got %v", http.StatusNotFound, rec.Code)
+				}
+				body := rec.Body.String()
+				shouldContain := "404 Not Found: /&lt;script&gt;alert(1)&lt;/script&gt;"
+				if !strings.Contains(body, shouldContain) {
+					t.Fatalf("%v should contain %v", body, shouldContain)
+				}
+				reqId := regexp.MustCompile(`Request ([0-9a-f]{8}:8100) at`).FindStringSubmatch(body)
+				if reqId == nil {
+					t.Fatalf("%v should contain the request id", body)
+				}
+				if !strings.Contains(logs.String(), ">>> " + reqId[1]) {
+					t.Fatalf("%v should contain the request id %v", logs.String(), reqId[1])
+				}
+				mimeText := "text/html; charset=utf-8"
+				contentType := rec.Header().Get("Content-type")
+				if contentType != mimeText {
+					t.Fatalf("Expected mime type %v but got %v", mimeText, contentType)
+				}
+			},
+		},
+		{
+			name: "Broken template",
+			method: "GET",
+			URL: "/fail",
+			tests: func (t *testing.T, logs *bytes.Buffer, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusInternalServerError {
+					t.Fatalf("Expected %v but got %v", http.StatusInternalServerError, rec.Code)
+				}
+				body := rec.Body.String()
+				if strings.Contains(body, "Unknown") {
+					t.Fatalf("%v should not contain the template source", body)
+				}
+				shouldContain := "Could not render error page /500.html.tmpl"
+				if !strings.Contains(logs.String(), shouldContain) {
+					t.Fatalf("%v should contain %v", logs.String(), shouldContain)
+				}
+			},
+		},
+	}
+
+	runLoggerTests(t, h, tests)
+}
+
+func TestAccessErrorPages(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.MkdirAll(tempDir + "/errors", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	err = os.MkdirAll(tempDir + "/internal", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/errors/403.html", []byte("<html><body>Access denied</body></html>"))
+	writeFile(tempDir + "/internal/429.html", []byte("<html><body>Internal slow down</body></html>"))
+	writeFile(tempDir + "/429.html", []byte("<html><body>Slow down</body></html>"))
+
+	pages, err := parseErrorPages(map[string]string{"403": "/errors/403.html", "429": "429.html"})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	ipRules := []IPAccessRule{{Deny: []string{"198.51.100.66"}}}
+	rateRules := []RateLimitRule{{Path: "/internal", Rate: 0.001, Burst: 1}}
+	for i := range ipRules {
+		if err := ipRules[i].finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	for i := range rateRules {
+		if err := rateRules[i].finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	content := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			http.Error(w, "forbidden by content", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("content"))
+	})
+	h := HandleClientIP(nil, "X-Forwarded-For", HandleAccessErrorPages(pages, fs, false, func(h http.Handler) http.Handler {
+		h = HandleIPAccess(ipRules, h)
+		return HandleRateLimits(rateRules, newRateLimitStore(), "test", h)
+	}, content))
+
+	tests := []struct {
+		name string
+		URL string
+		remoteAddr string
+		code int
+		shouldContain string
+		retryAfter bool
+	}{
+		{name: "Allowed", URL: "/index.html", remoteAddr: "198.51.100.7:1234", code: http.StatusOK, shouldContain: "content"},
+		{name: "Denied", URL: "/index.html", remoteAddr: "198.51.100.66:1234", code: http.StatusForbidden, shouldContain: "Access denied"},
+		{name: "Error of allowed request", URL: "/forbidden", remoteAddr: "198.51.100.7:1234", code: http.StatusForbidden, shouldContain: "forbidden by content"},
+		{name: "First request within rate", URL: "/internal/a", remoteAddr: "198.51.100.7:1234", code: http.StatusOK, shouldContain: "content"},
+		{name: "Relative page looked up from the docroot", URL: "/internal/a", remoteAddr: "198.51.100.7:1234", code: http.StatusTooManyRequests, shouldContain: "<body>Slow down", retryAfter: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			r.RemoteAddr = test.remoteAddr
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, test.shouldContain) {
+				t.Fatalf("%v should contain %v", body, test.shouldContain)
+			}
+			if test.retryAfter && rec.Header().Get("Retry-After") == "" {
+				t.Fatalf("Expected a Retry-After header")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultETagMaxSize keeps the first request for a large file from reading all of it before a byte is sent
+const defaultETagMaxSize = 64 << 20
+
+type fileHash struct {
+	modTime time.Time
+	size int64
+	etag string
+}
+
+// pendingHash is a hash in progress, done is closed once etag or err is set
+type pendingHash struct {
+	done chan bool
+	etag string
+	err error
+}
+
+// etagCache keeps strong ETags computed from the content of files.
+// Like the compressionCache, entries are identified by the name, modification time and size of the file,
+// so a file with a new modification time but the same content keeps its ETag.
+// Files larger than maxSize aren't hashed, concurrent requests for a file which is being hashed wait for that hash.
+type etagCache struct {
+	lock sync.Mutex
+	maxSize int64
+	files map[string]*fileHash
+	pending map[string]*pendingHash
+}
+
+// newETagCache returns a cache which hashes files up to maxSize bytes
+func newETagCache(maxSize int64) *etagCache {
+	return &etagCache{maxSize: maxSize, files: map[string]*fileHash{}, pending: map[string]*pendingHash{}}
+}
+
+// get returns the ETag of the file and seeks it back to its start, it's empty for files larger than maxSize
+func (c *etagCache) get(name string, fi os.FileInfo, f io.ReadSeeker) (string, error) {
+	if fi.Size() > c.maxSize {
+		return "", nil
+	}
+	c.lock.Lock()
+	cached, ok := c.files[name]
+	if ok && cached.modTime.Equal(fi.ModTime()) && cached.size == fi.Size() {
+		c.lock.Unlock()
+		return cached.etag, nil
+	}
+	version := fmt.Sprintf("%s %d %d", name, fi.ModTime().UnixNano(), fi.Size())
+	if p, ok := c.pending[version]; ok {
+		c.lock.Unlock()
+		<-p.done
+		return p.etag, p.err
+	}
+	p := &pendingHash{done: make(chan bool)}
+	c.pending[version] = p
+	c.lock.Unlock()
+
+	hash := sha256.New()
+	_, p.err = io.Copy(hash, f)
+	if _, seekErr := f.Seek(0, io.SeekStart); p.err == nil {
+		p.err = seekErr
+	}
+	if p.err == nil {
+		p.etag = `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	}
+	c.lock.Lock()
+	delete(c.pending, version)
+	if p.err == nil {
+		c.files[name] = &fileHash{modTime: fi.ModTime(), size: fi.Size(), etag: p.etag}
+	}
+	c.lock.Unlock()
+	close(p.done)
+	return p.etag, p.err
+}
+
+// precompute hashes all files below the docroot
+func (c *etagCache) precompute(fs http.FileSystem, docroot string) {
+	walkFiles(fs, docroot, func(name string, f http.File, fi os.FileInfo) {
+		if _, err := c.get(name, fi, f); err != nil {
+			log.Printf("Could not hash %s: %v", name, err)
+		}
+	})
+}
+
+// walkFiles opens all files below the docroot through the fs, names are relative to the docroot
+func walkFiles(fs http.FileSystem, docroot string, fn func(name string, f http.File, fi os.FileInfo)) {
+	err := filepath.Walk(docroot, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(docroot, file)
+		if err != nil {
+			return nil
+		}
+		name := "/" + filepath.ToSlash(rel)
+		f, fi, err := openFile(fs, name)
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+		fn(name, f, fi)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not walk %s: %v", docroot, err)
+	}
+}
+
+// HandleETags sets a strong ETag computed from the content of the requested file,
+// which http.ServeContent uses for If-None-Match, If-Match and If-Range.
+// An ETag which is already set is kept, files larger than the maxSize of the cache only get Last-Modified.
+func HandleETags(cache *etagCache, fs http.FileSystem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := requestedFile(r)
+		if !ok || w.Header().Get("ETag") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, fi, err := openFile(fs, name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		etag, err := cache.get(name, fi, f)
+		f.Close()
+		if err != nil {
+			log.Printf("Could not hash %s: %v", name, err)
+		} else if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
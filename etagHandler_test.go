@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestETagHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/index.html", []byte("<html></html>"))
+
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandleETags(newETagCache(defaultETagMaxSize), fs, http.FileServer(fs))
+	get := func(URL string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", URL, nil)
+		for name, value := range header {
+			r.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	etag := get("/test.txt", nil).Header().Get("ETag")
+	if len(etag) != 34 || etag[0] != '"' {
+		t.Fatalf("Expected a strong ETag but got %v", etag)
+	}
+	if index := get("/", nil).Header().Get("ETag"); index == "" || index == etag {
+		t.Fatalf("Expected a distinct ETag for the index but got %v", index)
+	}
+
+	tests := []struct {
+		name string
+		header map[string]string
+		code int
+	}{
+		{name: "If-None-Match", header: map[string]string{"If-None-Match": etag}, code: http.StatusNotModified},
+		{name: "If-None-Match other", header: map[string]string{"If-None-Match": `"other"`}, code: http.StatusOK},
+		{name: "If-Range", header: map[string]string{"Range": "bytes=0-1", "If-Range": etag}, code: http.StatusPartialContent},
+		{name: "If-Range other", header: map[string]string{"Range": "bytes=0-1", "If-Range": `"other"`}, code: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := get("/test.txt", test.header)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+		})
+	}
+
+	t.Run("New modification time keeps the ETag", func(t *testing.T) {
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(tempDir + "/test.txt", later, later); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		rec := get("/test.txt", map[string]string{"If-None-Match": etag})
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("Expected %v but got %v", http.StatusNotModified, rec.Code)
+		}
+	})
+
+	t.Run("New content changes the ETag", func(t *testing.T) {
+		writeFile(tempDir + "/test.txt", []byte("changed"))
+		rec := get("/test.txt", map[string]string{"If-None-Match": etag})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+		}
+		if rec.Header().Get("ETag") == etag {
+			t.Fatalf("Expected a new ETag but got %v", etag)
+		}
+	})
+}
+
+func TestETagMaxSize(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/large.txt", []byte(strings.Repeat("a", 100)))
+
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandleETags(newETagCache(10), fs, http.FileServer(fs))
+	for URL, hasETag := range map[string]bool{"/test.txt": true, "/large.txt": false} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", URL, nil))
+		if (rec.Header().Get("ETag") != "") != hasETag {
+			t.Fatalf("Expected ETag of %v: %v but got %v", URL, hasETag, rec.Header().Get("ETag"))
+		}
+		if rec.Header().Get("Last-Modified") == "" {
+			t.Fatalf("Expected Last-Modified for %v", URL)
+		}
+	}
+}
+
+// blockingReadSeeker is a blockingReader which can be seeked
+type blockingReadSeeker struct {
+	blockingReader
+}
+
+func (b *blockingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return b.r.(io.Seeker).Seek(offset, whence)
+}
+
+func TestETagCacheDeduplicates(t *testing.T) {
+	content := []byte(strings.Repeat("a", 1000))
+	fi := fileInfo{size: int64(len(content)), modTime: time.Now()}
+	cache := newETagCache(defaultETagMaxSize)
+	var readers int32
+	release := make(chan bool)
+	etags := make(chan string, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			etag, err := cache.get("/a.txt", fi, &blockingReadSeeker{blockingReader{readers: &readers, release: release, r: bytes.NewReader(content)}})
+			if err != nil {
+				t.Errorf("expected no error got %v", err)
+			}
+			etags <- etag
+		}()
+	}
+	for {
+		cache.lock.Lock()
+		pending := len(cache.pending)
+		cache.lock.Unlock()
+		if pending == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(etags)
+	if n := atomic.LoadInt32(&readers); n != 1 {
+		t.Fatalf("Expected the file to be hashed once but it was read %v times", n)
+	}
+	first := <-etags
+	for etag := range etags {
+		if etag == "" || etag != first {
+			t.Fatalf("Expected all requests to get the ETag %v but got %v", first, etag)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// ForwardAuthConfig asks an external endpoint whether a request may be served
+type ForwardAuthConfig struct {
+	// URL is the authorization endpoint, it's requested with GET for every protected request
+	URL string `yaml:"url"`
+	// Path is the protected path prefix (default: /)
+	Path string `yaml:"path"`
+	// Headers are request headers which are forwarded in addition to Cookie, e.g. Authorization
+	Headers []string `yaml:"headers"`
+	// AccessLogHeaders are headers of the authorization response which are added to the access log, e.g. X-User
+	AccessLogHeaders []string `yaml:"accessLogHeaders"`
+	// Timeout of the authorization request (default: 10s)
+	Timeout time.Duration `yaml:"timeout"`
+	client *http.Client
+}
+
+func (c *ForwardAuthConfig) finalize() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("url %s must be an absolute http or https url", c.URL)
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.Path[0] != '/' {
+		return fmt.Errorf("path %s must start with /", c.Path)
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	c.client = &http.Client{
+		Timeout: c.Timeout,
+		// redirects are answers of the endpoint, e.g. to a login page, and are passed on to the client
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return nil
+}
+
+// authRequest builds the request to the authorization endpoint describing the original request
+func (c *ForwardAuthConfig) authRequest(r *http.Request) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range append([]string{"Cookie"}, c.Headers...) {
+		for _, value := range r.Header.Values(name) {
+			req.Header.Add(name, value)
+		}
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	req.Header.Set("X-Forwarded-Method", r.Method)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	req.Header.Set("X-Forwarded-Host", r.Host)
+	req.Header.Set("X-Forwarded-Uri", r.URL.RequestURI())
+	if ip := clientIP(r); ip != nil {
+		req.Header.Set("X-Forwarded-For", ip.String())
+	}
+	return req, nil
+}
+
+// hopHeaders aren't copied from the authorization response, as they describe its connection
+var hopHeaders = []string{"Connection", "Content-Length", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// HandleForwardAuth serves requests below the protected path only if the authorization endpoint answers 2xx.
+// Its redirects, 401 and 403 responses are passed on to the client, other answers and errors result in a 502.
+func HandleForwardAuth(config *ForwardAuthConfig, h http.Handler) http.Handler {
+	if config == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !belowPath(config.Path, path.Clean("/" + r.URL.Path)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		req, err := config.authRequest(r)
+		if err != nil {
+			log.Printf("Could not authorize %s: %v", r.URL.Path, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		resp, err := config.client.Do(req)
+		if err != nil {
+			log.Printf("Could not authorize %s: %v", r.URL.Path, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			for _, name := range config.AccessLogHeaders {
+				if value := resp.Header.Get(name); value != "" {
+					addAccessLogField(r, name, value)
+				}
+			}
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			h.ServeHTTP(w, r)
+		case resp.StatusCode >= 300 && resp.StatusCode < 400, resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+			for name, values := range resp.Header {
+				w.Header()[name] = values
+			}
+			for _, name := range hopHeaders {
+				w.Header().Del(name)
+			}
+			w.WriteHeader(resp.StatusCode)
+			if r.Method != http.MethodHead {
+				_, _ = io.Copy(w, resp.Body)
+			}
+		default:
+			log.Printf("Could not authorize %s: %s answered %d", r.URL.Path, config.URL, resp.StatusCode)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForwardAuthHandler(t *testing.T) {
+	var authRequest *http.Request
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authRequest = r
+		switch r.Header.Get("Cookie") {
+		case "session=alice":
+			w.Header().Set("X-User", "alice")
+			w.WriteHeader(http.StatusNoContent)
+		case "session=bob":
+			http.Error(w, "bob may not read this", http.StatusForbidden)
+		case "session=broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Header().Set("Set-Cookie", "redirect=" + r.Header.Get("X-Forwarded-Uri"))
+			http.Redirect(w, r, "https://login.example.com/", http.StatusFound)
+		}
+	}))
+	defer auth.Close()
+
+	config := &ForwardAuthConfig{URL: auth.URL + "/verify", Path: "/internal", Headers: []string{"Authorization"}, AccessLogHeaders: []string{"X-User"}}
+	if err := config.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	h := LogAccess(true, "", HandleForwardAuth(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	})))
+
+	tests := []struct {
+		name string
+		URL string
+		cookie string
+		code int
+		contains string
+		log string
+	}{
+		{name: "Unprotected path", URL: "/public/doc.html", code: http.StatusOK, contains: "content"},
+		{name: "Allowed", URL: "/internal/doc.html?page=2", cookie: "session=alice", code: http.StatusOK, contains: "content", log: `/internal/doc.html X-User="alice"`},
+		{name: "Forbidden", URL: "/internal/doc.html", cookie: "session=bob", code: http.StatusForbidden, contains: "bob may not read this"},
+		{name: "Redirected to login", URL: "/internal/doc.html", code: http.StatusFound},
+		{name: "Unclean path", URL: "/public/../internal/doc.html", code: http.StatusFound},
+		{name: "Failing endpoint", URL: "/internal/doc.html", cookie: "session=broken", code: http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authRequest = nil
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = test.URL
+			if i := strings.Index(test.URL, "?"); i >= 0 {
+				r.URL.Path, r.URL.RawQuery = test.URL[:i], test.URL[i+1:]
+			}
+			r.Header.Set("Authorization", "Bearer token")
+			r.Header.Set("X-Other", "not forwarded")
+			if test.cookie != "" {
+				r.Header.Set("Cookie", test.cookie)
+			}
+			rec := httptest.NewRecorder()
+			l := log.Writer()
+			buf := &bytes.Buffer{}
+			log.SetOutput(buf)
+			defer log.SetOutput(l)
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), test.contains) {
+				t.Fatalf("%v should contain %v", rec.Body.String(), test.contains)
+			}
+			if !strings.Contains(buf.String(), test.log) {
+				t.Fatalf("%v should contain %v", buf.String(), test.log)
+			}
+			if test.name == "Unprotected path" {
+				if authRequest != nil {
+					t.Fatalf("Expected no authorization request")
+				}
+				return
+			}
+			if authRequest.URL.Path != "/verify" {
+				t.Fatalf("Expected %v but got %v", "/verify", authRequest.URL.Path)
+			}
+			if authRequest.Header.Get("Authorization") != "Bearer token" || authRequest.Header.Get("X-Other") != "" {
+				t.Fatalf("Expected only the configured headers to be forwarded but got %v", authRequest.Header)
+			}
+			if authRequest.Header.Get("X-Forwarded-Method") != "GET" {
+				t.Fatalf("Expected %v but got %v", "GET", authRequest.Header.Get("X-Forwarded-Method"))
+			}
+		})
+	}
+
+	t.Run("Forwarded URI and redirect", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/internal/doc.html?page=2", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if location := rec.Header().Get("Location"); location != "https://login.example.com/" {
+			t.Fatalf("Expected redirect %v but got %v", "https://login.example.com/", location)
+		}
+		if cookie := rec.Header().Get("Set-Cookie"); cookie != "redirect=/internal/doc.html?page=2" {
+			t.Fatalf("Expected %v but got %v", "redirect=/internal/doc.html?page=2", cookie)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// glob matches URL paths.
+// * and ? don't match /, ** matches anything including /.
+// Patterns without a / are matched against the last path element only (e.g. *.js or .env).
+type glob struct {
+	pattern string
+	baseName bool
+	re *regexp.Regexp
+}
+
+func compileGlob(pattern string) (*glob, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				re.WriteString(".*")
+				i++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid glob %s: missing ]", pattern)
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	compiled, err := regexp.Compile(re.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %s: %v", pattern, err)
+	}
+	return &glob{pattern: pattern, baseName: !strings.Contains(pattern, "/"), re: compiled}, nil
+}
+
+func (g *glob) match(name string) bool {
+	if g.baseName {
+		name = path.Base(name)
+	}
+	return g.re.MatchString(name)
+}
+
+type globList []*glob
+
+func compileGlobs(patterns []string) (globList, error) {
+	var globs globList
+	for _, pattern := range patterns {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+// match returns whether any of the globs matches the name
+func (l globList) match(name string) bool {
+	for _, g := range l {
+		if g.match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name string
+		matches bool
+	}{
+		{pattern: "*.js", name: "/assets/app.js", matches: true},
+		{pattern: "*.js", name: "/assets/app.css", matches: false},
+		{pattern: ".env", name: "/config/.env", matches: true},
+		{pattern: "/assets/*.js", name: "/assets/app.js", matches: true},
+		{pattern: "/assets/*.js", name: "/assets/vendor/app.js", matches: false},
+		{pattern: "/assets/**", name: "/assets/vendor/app.js", matches: true},
+		{pattern: "/assets/*-[0-9a-f]*.js", name: "/assets/app-3fa2.js", matches: true},
+		{pattern: "/assets/*-[!0-9]*.js", name: "/assets/app-3fa2.js", matches: false},
+		{pattern: "/file?.txt", name: "/file1.txt", matches: true},
+		{pattern: "/file?.txt", name: "/file/.txt", matches: false},
+		{pattern: "/a+b.txt", name: "/a+b.txt", matches: true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern + " " + test.name, func(t *testing.T) {
+			g, err := compileGlob(test.pattern)
+			if err != nil {
+				t.Fatalf("expected no error got %v", err)
+			}
+			if g.match(test.name) != test.matches {
+				t.Fatalf("Expected %v but got %v", test.matches, !test.matches)
+			}
+		})
+	}
+	if _, err := compileGlob("/assets/[a-z"); err == nil {
+		t.Fatalf("Expected an error for an unterminated character class")
+	}
+}
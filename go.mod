@@ -3,8 +3,10 @@ module github.com/kamphaus/static-serve
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/felixge/httpsnoop v1.0.1
 	github.com/google/uuid v1.2.0
-	github.com/howeyc/fsnotify v0.9.0 // indirect
-	github.com/kamphaus/memfs v1.0.0
+	github.com/howeyc/fsnotify v0.9.0
+	golang.org/x/crypto v0.1.0
+	gopkg.in/yaml.v2 v2.4.0
 )
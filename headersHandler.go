@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"github.com/felixge/httpsnoop"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// HeaderRule sets response headers for the files it matches.
+// All conditions of a rule must match, conditions which are not set match everything.
+type HeaderRule struct {
+	// Path is a glob matched against the URL path, e.g. /assets/*-*.js or *.html
+	Path string `yaml:"path"`
+	// Regex is a regular expression matched against the URL path
+	Regex string `yaml:"regex"`
+	// Types are MIME types, a trailing * matches all subtypes (e.g. image/*)
+	Types []string `yaml:"types"`
+	// Extensions are file extensions including the dot (e.g. .js)
+	Extensions []string `yaml:"extensions"`
+	CacheControl string `yaml:"cacheControl"`
+	// Expires sets the Expires header to the time of the response plus this duration
+	Expires time.Duration `yaml:"expires"`
+	// Set are arbitrary headers to set
+	Set map[string]string `yaml:"set"`
+	path *glob
+	regex *regexp.Regexp
+}
+
+func (rule *HeaderRule) finalize() error {
+	var err error
+	if rule.Path != "" {
+		if rule.path, err = compileGlob(rule.Path); err != nil {
+			return err
+		}
+	}
+	if rule.Regex != "" {
+		if rule.regex, err = regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("invalid regex %s: %v", rule.Regex, err)
+		}
+	}
+	if rule.CacheControl == "" && rule.Expires == 0 && len(rule.Set) == 0 {
+		return fmt.Errorf("rule sets no headers")
+	}
+	return nil
+}
+
+func (rule *HeaderRule) matches(urlPath string, contentType string) bool {
+	if rule.path != nil && !rule.path.match(urlPath) {
+		return false
+	}
+	if rule.regex != nil && !rule.regex.MatchString(urlPath) {
+		return false
+	}
+	if len(rule.Extensions) > 0 && !containsFold(rule.Extensions, path.Ext(urlPath)) {
+		return false
+	}
+	if len(rule.Types) > 0 && !matchesMimeType(rule.Types, contentType) {
+		return false
+	}
+	return true
+}
+
+func (rule *HeaderRule) apply(header http.Header, now time.Time) {
+	if rule.CacheControl != "" {
+		header.Set("Cache-Control", rule.CacheControl)
+	}
+	if rule.Expires != 0 {
+		header.Set("Expires", now.Add(rule.Expires).UTC().Format(http.TimeFormat))
+	}
+	for name, value := range rule.Set {
+		header.Set(name, value)
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMimeType returns whether the media type of the contentType is one of the types,
+// a trailing * matches all subtypes
+func matchesMimeType(types []string, contentType string) bool {
+	mimeType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range types {
+		if t == mimeType || strings.HasSuffix(t, "*") && strings.HasPrefix(mimeType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleHeaderRules sets the headers of all matching rules on successful and 304 responses,
+// later rules override the headers of earlier ones.
+// The rules are applied when the status is written, so that the content type is known.
+func HandleHeaderRules(rules []HeaderRule, h http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wroteHeader := false
+		writeHeader := func(next httpsnoop.WriteHeaderFunc, code int) {
+			if !wroteHeader && (code >= 200 && code < 300 || code == http.StatusNotModified) {
+				now := time.Now()
+				contentType := w.Header().Get("Content-Type")
+				for i := range rules {
+					if rules[i].matches(r.URL.Path, contentType) {
+						rules[i].apply(w.Header(), now)
+					}
+				}
+			}
+			wroteHeader = true
+			next(code)
+		}
+		hooks := httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					writeHeader(next, code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(p []byte) (int, error) {
+					if !wroteHeader {
+						writeHeader(w.WriteHeader, http.StatusOK)
+					}
+					return next(p)
+				}
+			},
+			ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					if !wroteHeader {
+						writeHeader(w.WriteHeader, http.StatusOK)
+					}
+					return next(src)
+				}
+			},
+		}
+		h.ServeHTTP(httpsnoop.Wrap(w, hooks), r)
+	})
+}
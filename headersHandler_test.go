@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderRulesHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/index.html", []byte("<html></html>"))
+	writeFile(tempDir + "/app.0123abcd.js", []byte("console.log(1)"))
+
+	rules := []HeaderRule{
+		{Types: []string{"text/*"}, CacheControl: "no-cache"},
+		{Regex: `\.[0-9a-f]{8}\.js$`, CacheControl: "public, max-age=31536000, immutable"},
+		{Path: "*.txt", Expires: time.Hour, Set: map[string]string{"X-Text": "yes"}},
+		{Extensions: []string{".js"}, Set: map[string]string{"X-Script": "yes"}},
+	}
+	for i := range rules {
+		if err := rules[i].finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandleHeaderRules(rules, http.FileServer(fs))
+
+	tests := []struct {
+		name string
+		URL string
+		status int
+		expected map[string]string
+	}{
+		{name: "MIME type", URL: "/", status: http.StatusOK, expected: map[string]string{"Cache-Control": "no-cache", "X-Text": ""}},
+		{name: "Later rule overrides", URL: "/app.0123abcd.js", status: http.StatusOK, expected: map[string]string{"Cache-Control": "public, max-age=31536000, immutable", "X-Script": "yes"}},
+		{name: "Glob with expires", URL: "/test.txt", status: http.StatusOK, expected: map[string]string{"Cache-Control": "no-cache", "X-Text": "yes"}},
+		{name: "Not applied to errors", URL: "/missing.txt", status: http.StatusNotFound, expected: map[string]string{"Cache-Control": "", "X-Text": ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.status {
+				t.Fatalf("Expected %v but got %v", test.status, rec.Code)
+			}
+			for name, value := range test.expected {
+				if rec.Header().Get(name) != value {
+					t.Fatalf("Expected %s %v but got %v", name, value, rec.Header().Get(name))
+				}
+			}
+		})
+	}
+
+	t.Run("Expires", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test.txt", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		expires, err := http.ParseTime(rec.Header().Get("Expires"))
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+			t.Fatalf("Expected Expires in an hour but got %v", expires)
+		}
+	})
+
+	t.Run("Not modified", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/test.txt", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		r = httptest.NewRequest("GET", "/test.txt", nil)
+		r.Header.Set("If-Modified-Since", rec.Header().Get("Last-Modified"))
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("Expected %v but got %v", http.StatusNotModified, rec.Code)
+		}
+		if rec.Header().Get("X-Text") != "yes" {
+			t.Fatalf("Expected X-Text %v but got %v", "yes", rec.Header().Get("X-Text"))
+		}
+	})
+}
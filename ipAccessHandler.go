@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// IPAccessRule restricts a path prefix to client addresses
+type IPAccessRule struct {
+	// Path is the path prefix the rule applies to (default: /)
+	Path string `yaml:"path"`
+	// Allow are the networks (CIDRs or addresses) which may access the path, all if it's empty
+	Allow []string `yaml:"allow"`
+	// Deny are the networks which may not access the path, they take precedence over Allow
+	Deny []string `yaml:"deny"`
+	// AllowFile and DenyFile contain further networks, one per line, they're reloaded when they change
+	AllowFile string `yaml:"allowFile"`
+	DenyFile string `yaml:"denyFile"`
+	allow ipList
+	deny ipList
+	allowFile *ipListFile
+	denyFile *ipListFile
+}
+
+func (rule *IPAccessRule) finalize() error {
+	if rule.Path == "" {
+		rule.Path = "/"
+	}
+	if !strings.HasPrefix(rule.Path, "/") {
+		return fmt.Errorf("path %s must start with /", rule.Path)
+	}
+	var err error
+	if rule.allow, err = parseIPList(rule.Allow); err != nil {
+		return err
+	}
+	if rule.deny, err = parseIPList(rule.Deny); err != nil {
+		return err
+	}
+	if rule.AllowFile != "" {
+		rule.allowFile = newIPListFile(rule.AllowFile)
+		if _, err := rule.allowFile.load(); err != nil {
+			return err
+		}
+	}
+	if rule.DenyFile != "" {
+		rule.denyFile = newIPListFile(rule.DenyFile)
+		if _, err := rule.denyFile.load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allows returns whether the address may access the path, files which can't be read deny all addresses
+func (rule *IPAccessRule) allows(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if rule.deny.contains(ip) {
+		return false
+	}
+	if rule.denyFile != nil {
+		if denied, ok := rule.denyFile.contains(ip); denied || !ok {
+			return false
+		}
+	}
+	if len(rule.allow) == 0 && rule.allowFile == nil {
+		return true
+	}
+	if rule.allow.contains(ip) {
+		return true
+	}
+	if rule.allowFile != nil {
+		allowed, ok := rule.allowFile.contains(ip)
+		return allowed && ok
+	}
+	return false
+}
+
+// ipListFile keeps the networks of a file until its modification time or size changes
+type ipListFile struct {
+	reloadableFile
+}
+
+func newIPListFile(name string) *ipListFile {
+	f := &ipListFile{reloadableFile{name: name, open: openOSFile}}
+	f.parse = f.parseList
+	return f
+}
+
+// parseList reads the networks of the file, lines may contain comments starting with #
+func (f *ipListFile) parseList(r io.Reader) (interface{}, error) {
+	var entries []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.SplitN(scanner.Text(), "#", 2)[0])
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	list, err := parseIPList(entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.name, err)
+	}
+	return list, nil
+}
+
+// contains returns whether the address is in the file, ok is false if the file couldn't be read
+func (f *ipListFile) contains(ip net.IP) (contains bool, ok bool) {
+	list, err := f.load()
+	if err != nil {
+		log.Printf("Could not read %s: %v", f.name, err)
+		return false, false
+	}
+	return list.(ipList).contains(ip), true
+}
+
+// HandleIPAccess answers 403 if the client address isn't allowed by the longest rule matching the path
+func HandleIPAccess(rules []IPAccessRule, h http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleanPath := path.Clean("/" + r.URL.Path)
+		var rule *IPAccessRule
+		for i := range rules {
+			if belowPath(rules[i].Path, cleanPath) && (rule == nil || len(rules[i].Path) > len(rule.Path)) {
+				rule = &rules[i]
+			}
+		}
+		if rule != nil && !rule.allows(clientIP(r)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIPAccessHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/office.txt", []byte("# office networks\n192.0.2.0/24\n2001:db8::/32 # vpn\n"))
+	rules := []IPAccessRule{
+		{Deny: []string{"198.51.100.66"}},
+		{Path: "/admin", AllowFile: tempDir + "/office.txt"},
+		{Path: "/admin/status", Allow: []string{"10.0.0.0/8"}},
+	}
+	for i := range rules {
+		if err := rules[i].finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	trusted, _ := parseIPList([]string{"10.1.0.0/16"})
+	h := HandleClientIP(trusted, "X-Forwarded-For", HandleIPAccess(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	})))
+
+	tests := []struct {
+		name string
+		URL string
+		remoteAddr string
+		forwardedFor string
+		code int
+	}{
+		{name: "Allowed by default", URL: "/index.html", remoteAddr: "198.51.100.7:1234", code: http.StatusOK},
+		{name: "Denied", URL: "/index.html", remoteAddr: "198.51.100.66:1234", code: http.StatusForbidden},
+		{name: "Allowed by file", URL: "/admin/", remoteAddr: "192.0.2.10:1234", code: http.StatusOK},
+		{name: "Allowed by IPv6 network of file", URL: "/admin/", remoteAddr: "[2001:db8::5]:1234", code: http.StatusOK},
+		{name: "Not in allow list", URL: "/admin/", remoteAddr: "198.51.100.7:1234", code: http.StatusForbidden},
+		{name: "Unclean path", URL: "/public/../admin/", remoteAddr: "198.51.100.7:1234", code: http.StatusForbidden},
+		{name: "Longest prefix wins", URL: "/admin/status", remoteAddr: "10.2.0.1:1234", code: http.StatusOK},
+		{name: "Client behind trusted proxy", URL: "/admin/", remoteAddr: "10.1.0.1:1234", forwardedFor: "192.0.2.10", code: http.StatusOK},
+		{name: "Spoofed header", URL: "/admin/", remoteAddr: "198.51.100.7:1234", forwardedFor: "192.0.2.10", code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL.Path = test.URL
+			r.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+		})
+	}
+
+	serve := func(remoteAddr string) int {
+		r := httptest.NewRequest("GET", "/admin/", nil)
+		r.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	t.Run("Reloaded on change", func(t *testing.T) {
+		writeFile(tempDir + "/office.txt", []byte("198.51.100.0/24\n"))
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(tempDir + "/office.txt", later, later); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if code := serve("198.51.100.7:1234"); code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, code)
+		}
+		if code := serve("192.0.2.10:1234"); code != http.StatusForbidden {
+			t.Fatalf("Expected %v but got %v", http.StatusForbidden, code)
+		}
+	})
+
+	t.Run("Missing file denies", func(t *testing.T) {
+		os.Remove(tempDir + "/office.txt")
+		if code := serve("198.51.100.7:1234"); code != http.StatusForbidden {
+			t.Fatalf("Expected %v but got %v", http.StatusForbidden, code)
+		}
+	})
+}
@@ -3,21 +3,42 @@ package main
 import (
 	"net/http"
 	"os"
+	"path"
 )
 
 /**
  * A filesystem which does not list the files inside a directory.
  * Can be used by http.FileServer to prevent serving of directory listings.
+ * Directories without an index.html don't exist for it, unless they are listed.
+ * Files hidden by the access policy (dotfiles by default) and the _headers and _redirects rule files
+ * don't exist for it either and are left out of listings.
  */
 type justFilesFilesystem struct {
 	fs http.FileSystem
+	access *AccessConfig
+	// listing configures which directories without an index.html are listed
+	listing *ListingConfig
 }
 
 func (fs justFilesFilesystem) Open(name string) (http.File, error) {
+	if fs.access.hidden(name) {
+		return nil, os.ErrNotExist
+	}
 	f, err := fs.fs.Open(name)
 	if err != nil {
 		return nil, err
 	}
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() {
+		return neuteredReaddirFile{f}, nil
+	}
+	if fs.listing.lists(name) {
+		return listedDirectory{f, fs, name}, nil
+	}
+	if !isFile(fs, path.Join(name, "index.html")) {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
 	return neuteredReaddirFile{f}, nil
 }
 
@@ -28,3 +49,25 @@ type neuteredReaddirFile struct {
 func (f neuteredReaddirFile) Readdir(count int) ([]os.FileInfo, error) {
 	return nil, nil
 }
+
+// listedDirectory lists only the files which can be opened
+type listedDirectory struct {
+	http.File
+	fs justFilesFilesystem
+	name string
+}
+
+func (f listedDirectory) Readdir(count int) ([]os.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	listed := infos[:0]
+	for _, info := range infos {
+		// opening the file applies the access and symlink policies
+		child, openErr := f.fs.Open(path.Join(f.name, info.Name()))
+		if openErr != nil {
+			continue
+		}
+		child.Close()
+		listed = append(listed, info)
+	}
+	return listed, err
+}
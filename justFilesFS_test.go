@@ -152,7 +152,7 @@ func TestRestrictedFS(t *testing.T) {
 	tempDir := setupFS()
 	defer cleanTempDir(tempDir)
 
-	fs := http.FileServer(justFilesFilesystem{http.Dir(tempDir)})
+	fs := http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)})
 
 	tests := []test{
 		{
@@ -170,17 +170,12 @@ func TestRestrictedFS(t *testing.T) {
 			},
 		},
 		{
-			name: "No directory returns listing",
+			name: "Directory without index returns 404",
 			method: "GET",
 			URL: "/",
 			tests: func (t *testing.T, rec *httptest.ResponseRecorder) {
-				if rec.Code != http.StatusOK {
-					t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
-				}
-				shouldBe := "<pre>\n</pre>\n"
-				body := string(rec.Body.Bytes())
-				if body != shouldBe {
-					t.Fatalf("Expected %v but got %v", shouldBe, body)
+				if rec.Code != http.StatusNotFound {
+					t.Fatalf("Expected %v but got %v", http.StatusNotFound, rec.Code)
 				}
 			},
 		},
@@ -214,7 +209,7 @@ func TestRestrictedFSWithIndexHtml(t *testing.T) {
 	defer cleanTempDir(tempDir)
 	writeFile(tempDir + "/index.html", []byte("<html><body>Foo bar</body></html>"))
 
-	fs := http.FileServer(justFilesFilesystem{http.Dir(tempDir)})
+	fs := http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)})
 
 	tests := []test{
 		{
@@ -255,3 +250,52 @@ func TestRestrictedFSWithIndexHtml(t *testing.T) {
 
 	runTests(t, fs, tests)
 }
+
+func TestRestrictedFSWithListing(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.Mkdir(tempDir + "/sub", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/.env", []byte("SECRET=1"))
+	writeFile(tempDir + "/_redirects", []byte("/a /b"))
+
+	fs := http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir), listing: &ListingConfig{}})
+
+	tests := []test{
+		{
+			name: "Directory without index returns listing",
+			method: "GET",
+			URL: "/",
+			tests: func (t *testing.T, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+				}
+				body := string(rec.Body.Bytes())
+				for _, shouldContain := range []string{`<a href="sub/">sub/</a>`, `<a href="test.js">test.js</a>`, `<a href="test.txt">test.txt</a>`} {
+					if !strings.Contains(body, shouldContain) {
+						t.Fatalf("%v should contain %v", body, shouldContain)
+					}
+				}
+				for _, hidden := range []string{".env", "_redirects"} {
+					if strings.Contains(body, hidden) {
+						t.Fatalf("%v should not contain %v", body, hidden)
+					}
+				}
+			},
+		},
+		{
+			name: "Empty directory returns listing",
+			method: "GET",
+			URL: "/sub/",
+			tests: func (t *testing.T, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+				}
+			},
+		},
+	}
+
+	runTests(t, fs, tests)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionLimits caps the concurrent connections of the site listeners, 0 means unlimited.
+// Idle keep-alive connections count as well, so the timeouts make idle and slow clients give their slots back.
+// The separate health listener is not limited.
+type ConnectionLimits struct {
+	// Max is the number of connections of all clients, further connections wait until one is closed
+	Max int `yaml:"max"`
+	// PerIP is the number of connections of a client address, further connections are closed right away
+	PerIP int `yaml:"perIP"`
+	// ReadHeaderTimeout is how long a client may take to send the headers of a request (default: 10s)
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	// IdleTimeout is how long a keep-alive connection may wait for the next request (default: 2m)
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+}
+
+func (c *ConnectionLimits) finalize() error {
+	if c.Max < 0 || c.PerIP < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if c.ReadHeaderTimeout < 0 || c.IdleTimeout < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = 10 * time.Second
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = 2 * time.Minute
+	}
+	return nil
+}
+
+// connLimiter counts the connections of all listeners, its limits can be changed on reload
+type connLimiter struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	limits ConnectionLimits
+	active int
+	perIP map[string]int
+}
+
+func newConnLimiter() *connLimiter {
+	c := &connLimiter{perIP: map[string]int{}}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+func (c *connLimiter) setLimits(limits ConnectionLimits) {
+	c.lock.Lock()
+	c.limits = limits
+	c.lock.Unlock()
+	c.cond.Broadcast()
+}
+
+// acquire waits until an accepted connection may be served, it returns false if the listener was closed meanwhile
+func (c *connLimiter) acquire(closed *int32) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for c.limits.Max > 0 && c.active >= c.limits.Max && atomic.LoadInt32(closed) == 0 {
+		c.cond.Wait()
+	}
+	if atomic.LoadInt32(closed) == 1 {
+		return false
+	}
+	c.active++
+	return true
+}
+
+func (c *connLimiter) release() {
+	c.lock.Lock()
+	c.active--
+	c.lock.Unlock()
+	c.cond.Broadcast()
+}
+
+func (c *connLimiter) acquireIP(ip string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.limits.PerIP > 0 && c.perIP[ip] >= c.limits.PerIP {
+		return false
+	}
+	c.perIP[ip]++
+	return true
+}
+
+func (c *connLimiter) releaseIP(ip string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.perIP[ip]--; c.perIP[ip] <= 0 {
+		delete(c.perIP, ip)
+	}
+}
+
+// limitListener accepts connections within the limits of the connLimiter
+type limitListener struct {
+	net.Listener
+	limiter *connLimiter
+	closed int32
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		// the slot is acquired after accepting, so that idle listeners don't hold slots of the shared limiter
+		if !l.limiter.acquire(&l.closed) {
+			// the listener was closed while waiting, the next Accept returns its error
+			conn.Close()
+			continue
+		}
+		ip := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if !l.limiter.acquireIP(ip) {
+			conn.Close()
+			l.limiter.release()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() {
+			l.limiter.releaseIP(ip)
+			l.limiter.release()
+		}}, nil
+	}
+}
+
+func (l *limitListener) Close() error {
+	// the lock keeps Accept from missing the wake-up between checking closed and waiting
+	l.limiter.lock.Lock()
+	atomic.StoreInt32(&l.closed, 1)
+	l.limiter.lock.Unlock()
+	l.limiter.cond.Broadcast()
+	return l.Listener.Close()
+}
+
+// limitedConn releases its slot of the connLimiter when it's closed
+type limitedConn struct {
+	net.Conn
+	once sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptConns accepts connections of the listener until it's closed
+func acceptConns(ln net.Listener) chan net.Conn {
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	return accepted
+}
+
+func dial(t *testing.T, ln net.Listener) net.Conn {
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	return conn
+}
+
+// isClosedByPeer returns whether the connection was closed by the listener
+func isClosedByPeer(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	limiter := newConnLimiter()
+	limiter.setLimits(ConnectionLimits{Max: 3, PerIP: 2})
+	ln := &limitListener{Listener: inner, limiter: limiter}
+	accepted := acceptConns(ln)
+
+	t.Run("Per IP", func(t *testing.T) {
+		first, second, third := dial(t, ln), dial(t, ln), dial(t, ln)
+		defer first.Close()
+		defer second.Close()
+		defer third.Close()
+		firstServer, secondServer := <-accepted, <-accepted
+		if !isClosedByPeer(third) {
+			t.Fatalf("Expected the third connection of the address to be closed")
+		}
+		firstServer.Close()
+		fourth := dial(t, ln)
+		defer fourth.Close()
+		fourthServer := <-accepted
+		if isClosedByPeer(fourth) {
+			t.Fatalf("Expected a connection to be accepted after another one was closed")
+		}
+		secondServer.Close()
+		fourthServer.Close()
+	})
+
+	t.Run("Global", func(t *testing.T) {
+		limiter.setLimits(ConnectionLimits{Max: 1})
+		first, second := dial(t, ln), dial(t, ln)
+		defer first.Close()
+		defer second.Close()
+		firstServer := <-accepted
+		select {
+		case <-accepted:
+			t.Fatalf("Expected the second connection to wait")
+		case <-time.After(200 * time.Millisecond):
+		}
+		firstServer.Close()
+		select {
+		case secondServer := <-accepted:
+			secondServer.Close()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected the second connection to be accepted after the first one was closed")
+		}
+	})
+
+	t.Run("Close while waiting", func(t *testing.T) {
+		held := dial(t, ln)
+		defer held.Close()
+		heldServer := <-accepted
+		defer heldServer.Close()
+		waiting := dial(t, ln)
+		defer waiting.Close()
+		time.Sleep(50 * time.Millisecond)
+		ln.Close()
+		select {
+		case _, ok := <-accepted:
+			if ok {
+				t.Fatalf("Expected no connection to be accepted")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected Accept to return after the listener was closed")
+		}
+	})
+}
+
+func TestLimitListenerSharedByListeners(t *testing.T) {
+	limiter := newConnLimiter()
+	limiter.setLimits(ConnectionLimits{Max: 2})
+	var listeners []*limitListener
+	for i := 0; i < 3; i++ {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		ln := &limitListener{Listener: inner, limiter: limiter}
+		defer ln.Close()
+		listeners = append(listeners, ln)
+	}
+	busy := acceptConns(listeners[0])
+	idle := acceptConns(listeners[1])
+	acceptConns(listeners[2])
+
+	for i := 0; i < 2; i++ {
+		conn := dial(t, listeners[0])
+		defer conn.Close()
+		select {
+		case server := <-busy:
+			defer server.Close()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected connection %v to be accepted while the other listeners are idle", i)
+		}
+	}
+	conn := dial(t, listeners[1])
+	defer conn.Close()
+	select {
+	case <-idle:
+		t.Fatalf("Expected the connection to wait, as the connections of all listeners are counted")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// listenerSpec describes what should be served on a port
+type listenerSpec struct {
+	port string
+	// certificates are selected by SNI, the first one is used if no certificate matches
+	certificates []tls.Certificate
+	handler http.Handler
+	// onClose releases the resources of the handler (e.g. FS watchers), may be nil
+	onClose func()
+	// unlimited listeners don't count towards the connection limits, e.g. the health listener
+	unlimited bool
+}
+
+// servedHandler is stored in an atomic.Value, which requires a consistent concrete type
+type servedHandler struct {
+	handler http.Handler
+	onClose func()
+}
+
+// listener is a running http.Server whose handler and certificate can be replaced
+// without closing the listening socket.
+type listener struct {
+	port string
+	tls bool
+	unlimited bool
+	server *http.Server
+	ln *onceCloseListener
+	handler atomic.Value
+	tlsConfig atomic.Value
+}
+
+func (l *listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.handler.Load().(servedHandler).handler.ServeHTTP(w, r)
+}
+
+func (l *listener) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return l.tlsConfig.Load().(*tls.Config), nil
+}
+
+// swap replaces the handler and certificates, requests already in flight finish with the previous handler
+func (l *listener) swap(spec listenerSpec) {
+	if len(spec.certificates) > 0 {
+		l.tlsConfig.Store(&tls.Config{Certificates: spec.certificates})
+	}
+	previous := l.handler.Load().(servedHandler)
+	l.handler.Store(servedHandler{spec.handler, spec.onClose})
+	if previous.onClose != nil {
+		previous.onClose()
+	}
+}
+
+// stop closes the listening socket immediately, so that the port can be reused,
+// and gracefully shuts down the server in the background.
+func (l *listener) stop(wg *sync.WaitGroup) {
+	l.ln.Close()
+	go func() {
+		defer wg.Done()
+		err := l.server.Shutdown(context.Background())
+		if err != nil {
+			log.Printf("HTTP server Shutdown error: %v", err)
+		}
+		if onClose := l.handler.Load().(servedHandler).onClose; onClose != nil {
+			onClose()
+		}
+	}()
+}
+
+// listen binds the port of the spec, its connections are counted by the limiter unless the spec is unlimited.
+// The listener doesn't accept connections until serve is called.
+func listen(spec listenerSpec, limiter *connLimiter, limits ConnectionLimits) (*listener, error) {
+	listenAddr := ":" + spec.port
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	if !spec.unlimited {
+		ln = &limitListener{Listener: ln, limiter: limiter}
+	}
+	l := &listener{
+		port: spec.port,
+		tls: len(spec.certificates) > 0,
+		unlimited: spec.unlimited,
+		ln: &onceCloseListener{Listener: ln},
+	}
+	l.handler.Store(servedHandler{spec.handler, spec.onClose})
+	l.server = &http.Server{
+		Addr: listenAddr,
+		Handler: l,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout,
+		IdleTimeout: limits.IdleTimeout,
+	}
+	if l.tls {
+		l.tlsConfig.Store(&tls.Config{Certificates: spec.certificates})
+		l.server.TLSConfig = &tls.Config{GetConfigForClient: l.getConfigForClient}
+	}
+	return l, nil
+}
+
+// serve accepts the connections of the listener in the background
+func (l *listener) serve() {
+	go func() {
+		var err error
+		if l.tls {
+			// certFile and keyFile are empty, since the certificate is passed in the server TLSConfig
+			err = l.server.ServeTLS(l.ln, "", "")
+		} else {
+			err = l.server.Serve(l.ln)
+		}
+		if err != http.ErrServerClosed && !l.ln.isClosed() {
+			log.Printf("Encountered error: %v", err)
+		}
+	}()
+}
+
+// serverManager keeps track of the running listeners and reconciles them with a new configuration
+type serverManager struct {
+	wg sync.WaitGroup
+	listeners map[string]*listener
+	// limiter caps the connections of all listeners, limits are applied to the servers they start
+	limiter *connLimiter
+	limits ConnectionLimits
+	// rateLimits keeps the token buckets of the sites across reloads
+	rateLimits *rateLimitStore
+}
+
+func newServerManager() *serverManager {
+	return &serverManager{listeners: map[string]*listener{}, limiter: newConnLimiter(), rateLimits: newRateLimitStore()}
+}
+
+// setConnectionLimits applies the limits to the running and future listeners,
+// servers whose timeouts change are restarted by the next apply
+func (m *serverManager) setConnectionLimits(limits ConnectionLimits) {
+	m.limiter.setLimits(limits)
+	m.limits = limits
+}
+
+// needsRestart returns whether the listener can't serve the spec by swapping its handler
+func (m *serverManager) needsRestart(l *listener, spec listenerSpec) bool {
+	return l.tls != (len(spec.certificates) > 0) || l.unlimited != spec.unlimited ||
+		l.server.ReadHeaderTimeout != m.limits.ReadHeaderTimeout || l.server.IdleTimeout != m.limits.IdleTimeout
+}
+
+// apply starts listeners for new ports, stops the listeners of removed ports
+// and swaps the handlers of unchanged ports in place.
+// New ports are bound first: if one of them cannot be bound, the running listeners are left untouched
+// and the handlers of the specs are closed.
+func (m *serverManager) apply(specs []listenerSpec) error {
+	wanted := map[string]listenerSpec{}
+	for _, spec := range specs {
+		wanted[spec.port] = spec
+	}
+	started := map[string]*listener{}
+	var errs []error
+	for _, spec := range specs {
+		if _, ok := m.listeners[spec.port]; ok {
+			continue
+		}
+		l, err := listen(spec, m.limiter, m.limits)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		started[spec.port] = l
+	}
+	if len(errs) > 0 {
+		for _, l := range started {
+			l.ln.Close()
+		}
+		for _, spec := range specs {
+			if spec.onClose != nil {
+				spec.onClose()
+			}
+		}
+		return fmt.Errorf("could not start all listeners: %v", errs)
+	}
+	// stop removed ports, ports which switch between HTTP and HTTPS or change their timeouts need a new server as well
+	for port, l := range m.listeners {
+		if spec, ok := wanted[port]; !ok || m.needsRestart(l, spec) {
+			log.Printf("Stopping listener on port: %s\n", port)
+			l.stop(&m.wg)
+			delete(m.listeners, port)
+		}
+	}
+	for _, spec := range specs {
+		if l, ok := m.listeners[spec.port]; ok {
+			l.swap(spec)
+			continue
+		}
+		l, ok := started[spec.port]
+		if !ok {
+			// the port was just released by its previous server
+			var err error
+			if l, err = listen(spec, m.limiter, m.limits); err != nil {
+				errs = append(errs, err)
+				if spec.onClose != nil {
+					spec.onClose()
+				}
+				continue
+			}
+		}
+		l.serve()
+		m.wg.Add(1)
+		m.listeners[spec.port] = l
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not start all listeners: %v", errs)
+	}
+	return nil
+}
+
+// shutdown gracefully stops all listeners and waits until they are done
+func (m *serverManager) shutdown() {
+	for port, l := range m.listeners {
+		l.stop(&m.wg)
+		delete(m.listeners, port)
+	}
+	m.wg.Wait()
+}
+
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	closed int32
+	err error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() {
+		atomic.StoreInt32(&l.closed, 1)
+		l.err = l.Listener.Close()
+	})
+	return l.err
+}
+
+func (l *onceCloseListener) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) == 1
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) string {
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func textHandler(text string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(text))
+	})
+}
+
+func getText(t *testing.T, port string) string {
+	resp, err := http.Get("http://localhost:" + port + "/")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestServerManagerSwapsHandlers(t *testing.T) {
+	manager := newServerManager()
+	defer manager.shutdown()
+	port := freePort(t)
+	closed := false
+
+	err := manager.apply([]listenerSpec{{port: port, handler: textHandler("first"), onClose: func() { closed = true }}})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	if body := getText(t, port); body != "first" {
+		t.Fatalf("Expected %v but got %v", "first", body)
+	}
+
+	err = manager.apply([]listenerSpec{{port: port, handler: textHandler("second")}})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	if body := getText(t, port); body != "second" {
+		t.Fatalf("Expected %v but got %v", "second", body)
+	}
+	if !closed {
+		t.Fatalf("Expected the previous handler to be closed")
+	}
+}
+
+func TestServerManagerStopsRemovedListeners(t *testing.T) {
+	manager := newServerManager()
+	defer manager.shutdown()
+	port := freePort(t)
+	otherPort := freePort(t)
+	started := make(chan bool)
+	release := make(chan bool)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("slow"))
+	})
+
+	err := manager.apply([]listenerSpec{{port: port, handler: slow}})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	inFlight := make(chan string)
+	go func() {
+		inFlight <- getText(t, port)
+	}()
+	<-started
+
+	err = manager.apply([]listenerSpec{{port: otherPort, handler: textHandler("other")}})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	if body := getText(t, otherPort); body != "other" {
+		t.Fatalf("Expected %v but got %v", "other", body)
+	}
+	if _, err := http.Get("http://localhost:" + port + "/"); err == nil {
+		t.Fatalf("Expected removed listener on port %v to be closed", port)
+	}
+
+	close(release)
+	if body := <-inFlight; body != "slow" {
+		t.Fatalf("Expected in-flight request to complete with %v but got %v", "slow", body)
+	}
+}
+
+func TestServerManagerKeepsRunningOnError(t *testing.T) {
+	manager := newServerManager()
+	defer manager.shutdown()
+	port := freePort(t)
+	removedPort := freePort(t)
+	blocker, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	defer blocker.Close()
+	blockedPort := strconv.Itoa(blocker.Addr().(*net.TCPAddr).Port)
+
+	var closedFirst, closedSecond int32
+	err = manager.apply([]listenerSpec{
+		{port: port, handler: textHandler("first"), onClose: func() { atomic.AddInt32(&closedFirst, 1) }},
+		{port: removedPort, handler: textHandler("removed")},
+	})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	err = manager.apply([]listenerSpec{
+		{port: port, handler: textHandler("second"), onClose: func() { atomic.AddInt32(&closedSecond, 1) }},
+		{port: blockedPort, handler: textHandler("blocked")},
+	})
+	if err == nil {
+		t.Fatalf("Expected an error for port %v which is already in use", blockedPort)
+	}
+	if body := getText(t, port); body != "first" {
+		t.Fatalf("Expected %v but got %v", "first", body)
+	}
+	if body := getText(t, removedPort); body != "removed" {
+		t.Fatalf("Expected %v but got %v", "removed", body)
+	}
+	if closed := atomic.LoadInt32(&closedFirst); closed != 0 {
+		t.Fatalf("Expected the running handler not to be closed but it was closed %v times", closed)
+	}
+	if closed := atomic.LoadInt32(&closedSecond); closed != 1 {
+		t.Fatalf("Expected the new handler to be closed once but it was closed %v times", closed)
+	}
+}
+
+func TestServerManagerTimeoutsFreeSlots(t *testing.T) {
+	manager := newServerManager()
+	defer manager.shutdown()
+	manager.setConnectionLimits(ConnectionLimits{Max: 1, ReadHeaderTimeout: 200 * time.Millisecond, IdleTimeout: 200 * time.Millisecond})
+	port := freePort(t)
+	healthPort := freePort(t)
+	err := manager.apply([]listenerSpec{
+		{port: port, handler: textHandler("site")},
+		{port: healthPort, handler: HandleHealthEndpoint(true, http.NotFoundHandler()), unlimited: true},
+	})
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(port string, path string) (int, error) {
+		resp, err := client.Get("http://localhost:" + port + path)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	t.Run("Idle connection gives its slot back", func(t *testing.T) {
+		idle, err := net.Dial("tcp", "localhost:" + port)
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		defer idle.Close()
+		idle.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(idle), nil)
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		resp.Body.Close()
+		// the idle keep-alive connection holds the only slot until the idle timeout closes it
+		if code, err := get(healthPort, "/health"); err != nil || code != http.StatusNoContent {
+			t.Fatalf("Expected the health port not to be limited but got %v %v", code, err)
+		}
+		if code, err := get(port, "/"); err != nil || code != http.StatusOK {
+			t.Fatalf("Expected the slot of the idle connection to be released but got %v %v", code, err)
+		}
+	})
+
+	t.Run("Slow headers give their slot back", func(t *testing.T) {
+		slow, err := net.Dial("tcp", "localhost:" + port)
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		defer slow.Close()
+		slow.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+		time.Sleep(50 * time.Millisecond)
+		if code, err := get(port, "/"); err != nil || code != http.StatusOK {
+			t.Fatalf("Expected the slot of the slow connection to be released but got %v %v", code, err)
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListingConfig configures the listings of directories without an index.html.
+// In a config file it's either true (list all directories) or a mapping.
+type ListingConfig struct {
+	// Paths restricts listings to directories matching these globs (e.g. /artifacts/**)
+	Paths []string `yaml:"paths"`
+	// Template is an html/template file rendered with listingData instead of the default listing
+	Template string `yaml:"template"`
+	// Sort is the default sort order: name (default), size or time, optionally followed by " desc"
+	Sort string `yaml:"sort"`
+	paths globList
+	template *template.Template
+}
+
+func (c *ListingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		if !enabled {
+			return fmt.Errorf("listing must be true or a mapping, omit it to disable listings")
+		}
+		return nil
+	}
+	type plain ListingConfig
+	return unmarshal((*plain)(c))
+}
+
+func (c *ListingConfig) finalize() error {
+	var err error
+	if c.paths, err = compileGlobs(c.Paths); err != nil {
+		return err
+	}
+	if c.Sort == "" {
+		c.Sort = "name"
+	}
+	if key, _ := parseListingSort(c.Sort); key == "" {
+		return fmt.Errorf("invalid sort %s, expected name, size or time optionally followed by desc", c.Sort)
+	}
+	content := defaultListingTemplate
+	if c.Template != "" {
+		file, err := ioutil.ReadFile(c.Template)
+		if err != nil {
+			return err
+		}
+		content = string(file)
+	}
+	c.template, err = template.New("listing").Funcs(listingFuncs).Parse(content)
+	return err
+}
+
+// lists returns whether the directory is listed
+func (c *ListingConfig) lists(dir string) bool {
+	if c == nil {
+		return false
+	}
+	if len(c.paths) == 0 {
+		return true
+	}
+	dir = path.Clean("/" + dir)
+	if dir != "/" {
+		dir += "/"
+	}
+	return c.paths.match(dir)
+}
+
+// parseListingSort parses a sort order like "size desc", the key is empty if it's invalid
+func parseListingSort(order string) (key string, desc bool) {
+	fields := strings.Fields(strings.ToLower(order))
+	if len(fields) == 0 || len(fields) > 2 || len(fields) == 2 && fields[1] != "desc" && fields[1] != "asc" {
+		return "", false
+	}
+	switch fields[0] {
+	case "name", "size", "time":
+		return fields[0], len(fields) == 2 && fields[1] == "desc"
+	}
+	return "", false
+}
+
+// listingEntry is a file or directory of a listing
+type listingEntry struct {
+	Name string `json:"name"`
+	// URL is the escaped relative link to the entry (./ keeps names with a colon from being read as a scheme),
+	// directories end with /
+	URL string `json:"url"`
+	IsDir bool `json:"isDir"`
+	Size int64 `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listingCrumb is a parent directory of the listed directory
+type listingCrumb struct {
+	Name string
+	URL string
+}
+
+// listingData is passed to listing templates
+type listingData struct {
+	Path string
+	Breadcrumbs []listingCrumb
+	Entries []listingEntry
+	// Sort is the sort key (name, size or time) and Desc whether the order is descending
+	Sort string
+	Desc bool
+}
+
+func breadcrumbs(dir string) []listingCrumb {
+	crumbs := []listingCrumb{{Name: "/", URL: "/"}}
+	current := "/"
+	for _, name := range splitPath(dir) {
+		current += url.PathEscape(name) + "/"
+		crumbs = append(crumbs, listingCrumb{Name: name, URL: current})
+	}
+	return crumbs
+}
+
+func sortEntries(entries []listingEntry, key string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "time":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
+
+var listingFuncs = template.FuncMap{
+	"humanSize": func(size int64) string {
+		const unit = 1024
+		if size < unit {
+			return strconv.FormatInt(size, 10) + " B"
+		}
+		div, exp := int64(unit), 0
+		for n := size / unit; n >= unit; n /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %ciB", float64(size) / float64(div), "KMGTPE"[exp])
+	},
+	// sortURL returns the query which sorts by the key, toggling the order if the listing is already sorted by it
+	"sortURL": func(data listingData, key string) string {
+		if data.Sort == key && !data.Desc {
+			return "?sort=" + key + "+desc"
+		}
+		return "?sort=" + key
+	},
+}
+
+const defaultListingTemplate = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Index of {{.Path}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: left; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<nav>{{range .Breadcrumbs}}<a href="{{.URL}}">{{.Name}}</a> {{end}}</nav>
+<table>
+<tr><th><a href="{{sortURL . "name"}}">Name</a></th><th><a href="{{sortURL . "size"}}">Size</a></th><th><a href="{{sortURL . "time"}}">Modified</a></th></tr>
+{{if gt (len .Breadcrumbs) 1}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{if not .IsDir}}{{humanSize .Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
+
+// HandleListing lists directories without an index.html for the configured paths,
+// as JSON if the client accepts application/json and otherwise as HTML.
+// The order can be selected with the sort query parameter (e.g. ?sort=time+desc).
+// Hidden files are left out, as the listing is read through the fs.
+func HandleListing(listing *ListingConfig, fs http.FileSystem, h http.Handler) http.Handler {
+	if listing == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir := r.URL.Path
+		if !strings.HasSuffix(dir, "/") || !listing.lists(dir) || isFile(fs, path.Join(dir, "index.html")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := fs.Open(dir)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			log.Printf("Could not list %s: %v", dir, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		data := listingData{Path: dir, Breadcrumbs: breadcrumbs(dir)}
+		for _, info := range infos {
+			entry := listingEntry{Name: info.Name(), URL: "./" + url.PathEscape(info.Name()), IsDir: info.IsDir(), Size: info.Size(), ModTime: info.ModTime()}
+			if entry.IsDir {
+				entry.URL += "/"
+				entry.Size = 0
+			}
+			data.Entries = append(data.Entries, entry)
+		}
+		data.Sort, data.Desc = parseListingSort(listing.Sort)
+		if key, desc := parseListingSort(r.URL.Query().Get("sort")); key != "" {
+			data.Sort, data.Desc = key, desc
+		}
+		sortEntries(data.Entries, data.Sort, data.Desc)
+
+		var b bytes.Buffer
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			if data.Entries == nil {
+				data.Entries = []listingEntry{}
+			}
+			err = json.NewEncoder(&b).Encode(struct {
+				Path string `json:"path"`
+				Entries []listingEntry `json:"entries"`
+			}{data.Path, data.Entries})
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			err = listing.template.Execute(&b, data)
+		}
+		if err != nil {
+			log.Printf("Could not render the listing of %s: %v", dir, err)
+			w.Header().Del("Content-Type")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		addVary(w.Header(), "Accept")
+		w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+		if r.Method != http.MethodHead {
+			w.Write(b.Bytes())
+		}
+	})
+}
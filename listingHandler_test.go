@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListingHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	for _, dir := range []string{"/artifacts/v1", "/artifacts/web", "/private"} {
+		if err := os.MkdirAll(tempDir + dir, 0755); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	writeFile(tempDir + "/artifacts/big.tar", make([]byte, 4096))
+	writeFile(tempDir + "/artifacts/a <b>.txt", []byte("a"))
+	writeFile(tempDir + "/artifacts/.secret", []byte("s"))
+	writeFile(tempDir + "/artifacts/web/index.html", []byte("<html>Web</html>"))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(tempDir + "/artifacts/big.tar", old, old)
+
+	listing := &ListingConfig{Paths: []string{"/artifacts/**"}}
+	if err := listing.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir), listing: listing}
+	h := HandleListing(listing, fs, http.FileServer(fs))
+	get := func(URL string, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", URL, nil)
+		r.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	t.Run("HTML", func(t *testing.T) {
+		rec := get("/artifacts/", "text/html")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+		}
+		body := rec.Body.String()
+		for _, shouldContain := range []string{`<a href="/artifacts/">artifacts</a>`, `<a href="./v1/">v1/</a>`, `a &lt;b&gt;.txt`, "4.0 KiB", `href="?sort=name&#43;desc"`} {
+			if !strings.Contains(body, shouldContain) {
+				t.Fatalf("%v should contain %v", body, shouldContain)
+			}
+		}
+		if strings.Contains(body, ".secret") {
+			t.Fatalf("%v should not contain %v", body, ".secret")
+		}
+	})
+
+	t.Run("JSON sorted by time", func(t *testing.T) {
+		rec := get("/artifacts/?sort=time+desc", "application/json")
+		if rec.Header().Get("Content-Type") != "application/json" {
+			t.Fatalf("Expected %v but got %v", "application/json", rec.Header().Get("Content-Type"))
+		}
+		var result struct {
+			Path string
+			Entries []listingEntry
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		var names []string
+		for _, entry := range result.Entries {
+			names = append(names, entry.Name)
+		}
+		expected := "web v1 a <b>.txt big.tar"
+		if result.Path != "/artifacts/" || strings.Join(names, " ") != expected {
+			t.Fatalf("Expected %v but got %v", expected, strings.Join(names, " "))
+		}
+	})
+
+	tests := []struct {
+		name string
+		URL string
+		code int
+		shouldContain string
+	}{
+		{name: "Empty directory", URL: "/artifacts/v1/", code: http.StatusOK, shouldContain: "Index of /artifacts/v1/"},
+		{name: "Directory with index", URL: "/artifacts/web/", code: http.StatusOK, shouldContain: "Web"},
+		{name: "Redirect to the directory", URL: "/artifacts/v1", code: http.StatusMovedPermanently},
+		{name: "Unlisted directory", URL: "/private/", code: http.StatusNotFound},
+		{name: "Unlisted root", URL: "/", code: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := get(test.URL, "text/html")
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			if body := rec.Body.String(); !strings.Contains(body, test.shouldContain) {
+				t.Fatalf("%v should contain %v", body, test.shouldContain)
+			}
+		})
+	}
+
+	t.Run("Custom template", func(t *testing.T) {
+		writeFile(tempDir + "/listing.tmpl", []byte(`{{range .Entries}}{{.Name}};{{end}}`))
+		custom := &ListingConfig{Template: tempDir + "/listing.tmpl", Sort: "size desc"}
+		if err := custom.finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		r := httptest.NewRequest("GET", "/artifacts/", nil)
+		rec := httptest.NewRecorder()
+		HandleListing(custom, fs, http.FileServer(fs)).ServeHTTP(rec, r)
+		if body := rec.Body.String(); body != "web;v1;big.tar;a &lt;b&gt;.txt;" {
+			t.Fatalf("Expected %v but got %v", "web;v1;big.tar;a &lt;b&gt;.txt;", body)
+		}
+	})
+}
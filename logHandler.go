@@ -2,19 +2,40 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/felixge/httpsnoop"
 	"github.com/google/uuid"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"strings"
 )
 
+type contextKey string
+
+// accessLogFields are logged after the path of a request by LogAccess
+type accessLogFields struct {
+	fields []string
+}
+
+const accessLogFieldsKey = contextKey("accessLogFields")
+
+// addAccessLogField adds a field to the access log line of the request, it's ignored if access logging is disabled
+func addAccessLogField(r *http.Request, name string, value string) {
+	if f, ok := r.Context().Value(accessLogFieldsKey).(*accessLogFields); ok {
+		f.fields = append(f.fields, fmt.Sprintf("%s=%q", name, value))
+	}
+}
+
 func LogAccess(logAccessFlag bool, prefix string, h http.Handler) http.Handler {
 	if !logAccessFlag {
 		return h
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := &accessLogFields{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogFieldsKey, fields))
 		var (
 			httpCode = http.StatusOK
 			writtenBytes int64 = 0
@@ -43,10 +64,58 @@ func LogAccess(logAccessFlag bool, prefix string, h http.Handler) http.Handler {
 		)
 		wrapped := httpsnoop.Wrap(w, hooks)
 		h.ServeHTTP(wrapped, r)
-		log.Printf("%s %d %d %s", r.RemoteAddr, httpCode, writtenBytes, prefix + r.URL.Path)
+		if len(fields.fields) == 0 {
+			log.Printf("%s %d %d %s", clientAddress(r), httpCode, writtenBytes, prefix + r.URL.Path)
+		} else {
+			log.Printf("%s %d %d %s %s", clientAddress(r), httpCode, writtenBytes, prefix + r.URL.Path, strings.Join(fields.fields, " "))
+		}
 	})
 }
 
+const requestIDKey = contextKey("requestID")
+
+// RequestID returns the id under which LogReqResponse logged the request, empty if it wasn't logged
+func RequestID(r *http.Request) string {
+	reqId, _ := r.Context().Value(requestIDKey).(string)
+	return reqId
+}
+
+// redactedHeaders contain credentials, only the scheme of authorization headers and the names of cookies are logged
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+func redactHeaderValue(name string, value string) string {
+	switch name {
+	case "Cookie":
+		cookies := strings.Split(value, ";")
+		for i, cookie := range cookies {
+			cookies[i] = strings.SplitN(strings.TrimSpace(cookie), "=", 2)[0] + "=[REDACTED]"
+		}
+		return strings.Join(cookies, "; ")
+	case "Set-Cookie":
+		return strings.SplitN(value, "=", 2)[0] + "=[REDACTED]"
+	}
+	return strings.SplitN(value, " ", 2)[0] + " [REDACTED]"
+}
+
+// redactCredentials returns a copy of the headers without credentials, the headers are returned if they contain none
+func redactCredentials(header http.Header) http.Header {
+	redacted, cloned := header, false
+	for _, name := range redactedHeaders {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if !cloned {
+			redacted, cloned = header.Clone(), true
+		}
+		redacted.Del(name)
+		for _, value := range values {
+			redacted.Add(name, redactHeaderValue(name, value))
+		}
+	}
+	return redacted
+}
+
 func LogReqResponse(logReqResponse bool, postfix string, h http.Handler) http.Handler {
 	if !logReqResponse {
 		return h
@@ -54,6 +123,7 @@ func LogReqResponse(logReqResponse bool, postfix string, h http.Handler) http.Ha
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		reqUuid, _ := uuid.NewRandom()
 		reqId := reqUuid.String()[:8] + postfix
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, reqId))
 		var (
 			respCode = http.StatusOK
 			hooks = httpsnoop.Hooks{
@@ -66,11 +136,13 @@ func LogReqResponse(logReqResponse bool, postfix string, h http.Handler) http.Ha
 			}
 		)
 		wrapped := httpsnoop.Wrap(w, hooks)
-		reqHeaders, _ := httputil.DumpRequest(r, false)
+		logged := r.WithContext(r.Context())
+		logged.Header = redactCredentials(r.Header)
+		reqHeaders, _ := httputil.DumpRequest(logged, false)
 		log.Printf(">>> %s %s", reqId, reqHeaders)
 		h.ServeHTTP(wrapped, r)
 		var b bytes.Buffer
-		_ = wrapped.Header().WriteSubset(&b, map[string]bool{})
+		_ = redactCredentials(wrapped.Header()).WriteSubset(&b, map[string]bool{})
 		log.Printf("<<< %s %d %s\n", reqId, respCode, b.String())
 	})
 }
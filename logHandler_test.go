@@ -38,7 +38,7 @@ func TestDeactivatedLog(t *testing.T) {
 	tempDir := setupFS()
 	defer cleanTempDir(tempDir)
 
-	h := LogAccess(false, "", http.FileServer(justFilesFilesystem{http.Dir(tempDir)}))
+	h := LogAccess(false, "", http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
 
 	tests := []loggerTest{
 		{
@@ -94,7 +94,7 @@ func TestWithLogging(t *testing.T) {
 	tempDir := setupFS()
 	defer cleanTempDir(tempDir)
 
-	h := LogAccess(true, "", http.FileServer(justFilesFilesystem{http.Dir(tempDir)}))
+	h := LogAccess(true, "", http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
 
 	tests := []loggerTest{
 		{
@@ -145,3 +145,56 @@ func TestWithLogging(t *testing.T) {
 
 	runLoggerTests(t, h, tests)
 }
+
+func TestReqResponseLogRedactsCredentials(t *testing.T) {
+	h := LogReqResponse(true, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			t.Fatalf("Expected the handler to get the credentials")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("user", "secret")
+	w := httptest.NewRecorder()
+	l := log.Writer()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(l)
+	h.ServeHTTP(w, r)
+	logStr := buf.String()
+	if strings.Contains(logStr, "dXNlcjpzZWNyZXQ=") {
+		t.Fatalf("%v should not contain the credentials", logStr)
+	}
+	if !strings.Contains(logStr, "Authorization: Basic [REDACTED]") {
+		t.Fatalf("%v should contain %v", logStr, "Authorization: Basic [REDACTED]")
+	}
+}
+
+func TestReqResponseLogRedactsCookies(t *testing.T) {
+	h := LogReqResponse(true, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "new-secret", Path: "/"})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "session=old-secret; theme=dark")
+	w := httptest.NewRecorder()
+	l := log.Writer()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(l)
+	h.ServeHTTP(w, r)
+	logStr := buf.String()
+	for _, secret := range []string{"old-secret", "new-secret", "dark"} {
+		if strings.Contains(logStr, secret) {
+			t.Fatalf("%v should not contain %v", logStr, secret)
+		}
+	}
+	for _, expected := range []string{"Cookie: session=[REDACTED]; theme=[REDACTED]", "Set-Cookie: session=[REDACTED]"} {
+		if !strings.Contains(logStr, expected) {
+			t.Fatalf("%v should contain %v", logStr, expected)
+		}
+	}
+	if w.Header().Get("Set-Cookie") != "session=new-secret; Path=/" {
+		t.Fatalf("Expected the cookie to be set but got %v", w.Header().Get("Set-Cookie"))
+	}
+}
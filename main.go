@@ -5,25 +5,29 @@ Usage:
 	-d=".":    the directory of static files to host
 	-e="":     a file to serve in case of error 404
 	-l:        log access requests
+	-config="": a YAML file describing the sites to serve (replaces the other flags)
 
-Static-serve does not show directory listings, it only serves files.
+	static-serve sign [-secret=... | -config=...] [-expires=24h] <path>
+	prints a link to the path which is signed for the signedURLs of a site
+
+Static-serve does not show directory listings unless -listing is set, it only serves files.
+Sending SIGHUP reloads the flags or config file without dropping connections.
 */
 package main
 
 import (
-	"context"
 	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/kamphaus/memfs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 )
 
 type arrayFlags []string
@@ -61,9 +65,13 @@ var ports arrayFlags
 var directories arrayFlags
 var error404s arrayFlags
 var fsType = DiskFS
+var symlinks SymlinkPolicy = SymlinksWithinRoot
 
 func main() {
 	log.SetFlags(0)
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		os.Exit(signCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
 	flag.Var(&ports, "p", "ports to serve on (default: 8100)")
 	flag.Var(&directories, "d", "the directories of static files to host (default: ./)")
 	flag.Var(&error404s, "e", "the files to serve in case of error 404 (- to disable error404 handler)")
@@ -71,6 +79,10 @@ func main() {
 		"* "+string(DiskFS)+"        Load files directly from disk (Kernel takes care of caching)\n" +
 		"* "+string(INMem)+"         Eagerly loads files from directories into memory and serves them from memory\n" +
 		"* "+string(INMemWithoutWatch)+" Same as "+string(INMem)+", but doesn't watch for changes (ideal for docker containers)\n")
+	flag.Var(&symlinks, "symlinks", "How symlinks in the directories are handled. Options:\n" +
+		"* "+string(SymlinksDeny)+"        Don't serve files behind symlinks\n" +
+		"* "+string(SymlinksWithinRoot)+" Follow symlinks whose targets are within the directory (default)\n" +
+		"* "+string(SymlinksFollow)+"      Follow all symlinks\n")
 	logAccessFlag := flag.Bool("l", false, "log access requests")
 	logHeadersFlag := flag.Bool("r", false, "log request/response headers")
 	verboseFlag := flag.Bool("v", false, "verbose logging (e.g. when handling error 404)")
@@ -78,6 +90,9 @@ func main() {
 	tlsKeyFlag := flag.String("tls-key", "", "path to the key of the TLS certificate")
 	versionFlag := flag.Bool("version", false, "print the version and exit")
 	healthPortFlag := flag.String("hport", "", "the port on which /health and /ready endpoints should be served")
+	error404StatusFlag := flag.Bool("e-status", false, "send the error 404 files with status 404 instead of 200")
+	listingFlag := flag.Bool("listing", false, "list the files of directories without an index.html instead of answering 404")
+	configFlag := flag.String("config", "", "path to a YAML config file describing the sites to serve (replaces all other flags)")
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
@@ -90,76 +105,233 @@ func main() {
 		return
 	}
 
-	if len(ports) != len(directories) || len(ports) != len(error404s) {
+	if *configFlag != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "config" {
+				log.Fatalf("Flag -%s cannot be combined with -config", f.Name)
+			}
+		})
+	} else if len(ports) != len(directories) || len(ports) != len(error404s) {
 		flag.Usage()
 		os.Exit(1)
 		return
 	}
-	if len(ports) == 0 {
-		ports = append(ports, "8100")
-		directories = append(directories, ".")
-		error404s = append(error404s, "-")
+	readConfig := func() (*Config, error) {
+		if *configFlag != "" {
+			cfg, err := loadConfig(*configFlag)
+			if err != nil {
+				return nil, fmt.Errorf("could not load config %s: %v", *configFlag, err)
+			}
+			return cfg, nil
+		}
+		return configFromFlags(*logAccessFlag, *logHeadersFlag, *verboseFlag, *error404StatusFlag, *listingFlag, *tlsCertFlag, *tlsKeyFlag, *healthPortFlag)
 	}
 
-	if *verboseFlag {
-		memfs.SetLogger(memfs.Verbose)
-		log.Printf("Verbose logging is activated\n")
+	manager := newServerManager()
+	if err := load(manager, readConfig); err != nil {
+		log.Fatal(err)
 	}
-	if *logAccessFlag {
-		log.Printf("Access logging is activated\n")
+
+	// run until we get a signal, SIGHUP reloads the configuration
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, os.Kill, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Printf("Reloading...")
+		if err := load(manager, readConfig); err != nil {
+			log.Printf("Reload failed: %v", err)
+			continue
+		}
+		log.Printf("Reload complete")
 	}
-	if *logHeadersFlag {
-		log.Printf("Request / response logging is activated\n")
+	log.Printf("Shutting down...")
+	manager.shutdown()
+	log.Printf("Shutdown complete")
+}
+
+// load reads the configuration and applies it to the running listeners
+func load(manager *serverManager, readConfig func() (*Config, error)) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
 	}
-	tlsConfig := loadTlsConfig(*verboseFlag, *tlsCertFlag, *tlsKeyFlag)
+	if cfg.Verbose {
+		log.Printf("Verbose logging is activated\n")
+	}
+	manager.rateLimits.prune(time.Now())
+	specs, err := buildListeners(cfg, manager.rateLimits)
+	if err != nil {
+		return err
+	}
+	manager.setConnectionLimits(cfg.Connections)
+	return manager.apply(specs)
+}
 
-	serveHPort := *healthPortFlag != ""
-	if serveHPort {
-		log.Printf("Serving health endpoints on port: %s\n", *healthPortFlag)
+// buildListeners creates the handlers for all sites and the health endpoints.
+// Nothing is kept open if one of them cannot be created.
+func buildListeners(cfg *Config, rateLimits *rateLimitStore) ([]listenerSpec, error) {
+	var specs []listenerSpec
+	closeAll := func() {
+		for _, spec := range specs {
+			if spec.onClose != nil {
+				spec.onClose()
+			}
+		}
+	}
+	for _, port := range cfg.ports() {
+		spec, err := buildListener(cfg, port, rateLimits)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	if cfg.Health.Port != "" {
+		log.Printf("Serving health endpoints on port: %s\n", cfg.Health.Port)
 	}
-	hportServed := false
+	if cfg.Health.Port != "" && !cfg.servesHealthPort() {
+		certificate, err := loadCertificate(cfg.Health.TLS.Cert, cfg.Health.TLS.Key)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("health port %s: %v", cfg.Health.Port, err)
+		}
+		var certificates []tls.Certificate
+		if certificate != nil {
+			certificates = append(certificates, *certificate)
+		}
+		hport := ":" + cfg.Health.Port
+		specs = append(specs, listenerSpec{
+			port: cfg.Health.Port,
+			certificates: certificates,
+			handler: LogAccess(cfg.Health.LogAccess, hport, HandleHealthEndpoint(true, http.NotFoundHandler())),
+			// health checks must keep working when clients of the sites exhaust the connection limits
+			unlimited: true,
+		})
+	}
+	return specs, nil
+}
 
-	var servers []*http.Server
-	var done sync.WaitGroup
-	done.Add(len(ports))
-	for i := range ports {
-		port := ports[i]
-		directory := directories[i]
-		error404File := error404s[i]
-		if error404File == "-" {
-			error404File = ""
-		}
-		servingHPort := serveHPort && port == *healthPortFlag
-		servers = append(servers, serve(&done, port, directory, tlsConfig, error404File, len(ports), fsType, *logAccessFlag, *logHeadersFlag, *verboseFlag, servingHPort))
-		if servingHPort {
-			hportServed = true
-		}
-	}
-	if serveHPort && !hportServed {
-		done.Add(1)
-		hport := ":" + *healthPortFlag
-		servers = append(servers, startServer(
-			&done,
-			hport,
-			tlsConfig,
-			LogAccess(*logAccessFlag, hport, HandleHealthEndpoint(true, http.NotFoundHandler())),
-			nil,
-		))
-	}
-
-	// run until we get a signal
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, os.Kill)
-	<-quit
-	log.Printf("Shutting down...")
-	for _, server := range servers {
-		err := server.Shutdown(context.Background())
+// buildListener creates the handlers of all sites sharing a port and dispatches between them by host
+func buildListener(cfg *Config, port string, rateLimits *rateLimitStore) (listenerSpec, error) {
+	var onCloses []func()
+	closeSites := func() {
+		for _, onClose := range onCloses {
+			onClose()
+		}
+	}
+	var certificates []tls.Certificate
+	hosts := map[string]http.Handler{}
+	var patterns []*hostPattern
+	var fallback http.Handler
+	for i := range cfg.Sites {
+		site := &cfg.Sites[i]
+		if site.Port != port {
+			continue
+		}
+		certificate, err := loadCertificate(site.TLS.Cert, site.TLS.Key)
+		if err != nil {
+			closeSites()
+			return listenerSpec{}, fmt.Errorf("site %s: %v", site.name(), err)
+		}
+		if site.isDynamic() {
+			cache := newSiteCache(site.IdleTimeout, func(directory string, host string) (http.Handler, func(), error) {
+				dynamicSite := *site
+				dynamicSite.Directory = directory
+				dynamicSite.Hosts = []string{host}
+				return serve(&dynamicSite, len(cfg.Sites), cfg.Verbose, rateLimits)
+			})
+			onCloses = append(onCloses, cache.close)
+			for _, host := range site.Hosts {
+				pattern, _ := parseHostPattern(host)
+				directory := site.Directory
+				pattern.handler = func(captures []string) http.Handler {
+					return cache.handler(expandDirectory(directory, captures), pattern.expand(captures))
+				}
+				patterns = append(patterns, pattern)
+			}
+			log.Printf("Serving %s on HTTP port: %s for %s\n", site.Directory, site.Port, strings.Join(site.Hosts, ", "))
+			if certificate != nil {
+				certificates = append(certificates, *certificate)
+			}
+			continue
+		}
+		handler, onClose, err := serve(site, len(cfg.Sites), cfg.Verbose, rateLimits)
 		if err != nil {
-			log.Printf("HTTP server Shutdown error: %v", err)
+			closeSites()
+			return listenerSpec{}, fmt.Errorf("site %s: %v", site.name(), err)
+		}
+		onCloses = append(onCloses, onClose)
+		for _, host := range site.Hosts {
+			if !isHostPattern(host) {
+				hosts[host] = handler
+				continue
+			}
+			pattern, _ := parseHostPattern(host)
+			pattern.handler = func([]string) http.Handler {
+				return handler
+			}
+			patterns = append(patterns, pattern)
+		}
+		if site.isDefault() {
+			fallback = handler
+			if certificate != nil {
+				certificates = append([]tls.Certificate{*certificate}, certificates...)
+			}
+		} else if certificate != nil {
+			certificates = append(certificates, *certificate)
 		}
 	}
-	done.Wait()
-	log.Printf("Shutdown complete")
+	if fallback == nil {
+		fallback = HandleHealthEndpoint(cfg.Health.Port == port, http.NotFoundHandler())
+	}
+	return listenerSpec{
+		port: port,
+		certificates: certificates,
+		handler: HandleVirtualHosts(hosts, patterns, fallback),
+		onClose: closeSites,
+	}, nil
+}
+
+// configFromFlags builds the configuration from the repeated -p, -d and -e flags.
+// All other flags apply to every site.
+func configFromFlags(logAccess bool, logHeaders bool, verbose bool, error404Status bool, listAll bool, tlsCert string, tlsKey string, healthPort string) (*Config, error) {
+	if len(ports) == 0 {
+		ports = append(ports, "8100")
+		directories = append(directories, ".")
+		error404s = append(error404s, "-")
+	}
+	cfg := &Config{
+		Verbose: verbose,
+		Health: HealthConfig{
+			Port: healthPort,
+			TLS: TLSFiles{Cert: tlsCert, Key: tlsKey},
+			LogAccess: logAccess,
+		},
+	}
+	var listing *ListingConfig
+	if listAll {
+		listing = &ListingConfig{}
+	}
+	for i := range ports {
+		cfg.Sites = append(cfg.Sites, SiteConfig{
+			Port: ports[i],
+			Directory: directories[i],
+			FSType: fsType,
+			Symlinks: symlinks,
+			Error404: error404s[i],
+			Error404KeepStatus: error404Status,
+			Listing: listing,
+			TLS: TLSFiles{Cert: tlsCert, Key: tlsKey},
+			LogAccess: logAccess,
+			LogHeaders: logHeaders,
+		})
+	}
+	if err := cfg.finalize(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
 type closeableFS interface {
@@ -167,66 +339,95 @@ type closeableFS interface {
 	Close() error
 }
 
-func serve(wg *sync.WaitGroup, port string, directory string, tlsConfig *tls.Config, error404File string, numPorts int, fsType FSType, logAccess bool, logHeadersFlag bool, error404Verbose bool, serveHealth bool) *http.Server {
-	docroot, err := filepath.Abs(directory)
+// serve builds the handler chain for a site, the returned function closes the FS watchers
+func serve(site *SiteConfig, numPorts int, error404Verbose bool, rateLimits *rateLimitStore) (http.Handler, func(), error) {
+	docrootPath, err := filepath.Abs(site.Directory)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
+	root := newDocroot(docrootPath, site.Symlinks)
 	var fs http.FileSystem
 	var closeFS closeableFS
-	if fsType == INMem {
-		fs, err = memfs.NewWithWatch(docroot, true)
-		if closeableFS, ok := fs.(closeableFS); ok {
-			closeFS = closeableFS
+	if site.FSType == INMem || site.FSType == INMemWithoutWatch {
+		memoryFS, err := newMemoryFilesystem(root, site.FSType == INMem, error404Verbose)
+		if err != nil {
+			return nil, nil, err
 		}
-	} else if fsType == INMemWithoutWatch {
-		fs, err = memfs.NewWithWatch(docroot, false)
+		fs, closeFS = memoryFS, memoryFS
 	} else {
-		fs = http.Dir(docroot)
+		fs = diskFilesystem{root}
 	}
-	fs = justFilesFilesystem{fs}
+	netlify := newNetlifyRules(fs)
+	fs = justFilesFilesystem{fs: fs, access: site.Access, listing: site.Listing}
 
 	withError404 := ""
-	if error404File != "" {
-		withError404 = fmt.Sprintf(" with %s as error 404 file", error404File)
+	if site.Error404 != "" {
+		withError404 = fmt.Sprintf(" with %s as error 404 file", site.Error404)
+	}
+	withHosts := ""
+	if len(site.Hosts) > 0 {
+		withHosts = fmt.Sprintf(" for %s", strings.Join(site.Hosts, ", "))
 	}
-	log.Printf("Serving %s on HTTP port: %s%s\n", docroot, port, withError404)
-	listenAddr := ":" + port
-	logPrefix := listenAddr
-	if numPorts == 1 {
+	log.Printf("Serving %s on HTTP port: %s%s%s\n", docrootPath, site.Port, withHosts, withError404)
+	if site.LogAccess {
+		log.Printf("Access logging is activated on port: %s\n", site.Port)
+	}
+	if site.LogHeaders {
+		log.Printf("Request / response logging is activated on port: %s\n", site.Port)
+	}
+	logPrefix := ":" + site.Port
+	if len(site.Hosts) > 0 {
+		logPrefix = site.Hosts[0]
+	} else if numPorts == 1 {
 		logPrefix = ""
 	}
-	return startServer(
-		wg,
-		listenAddr,
-		tlsConfig,
-		LogAccess(logAccess, logPrefix, LogReqResponse(logHeadersFlag, logPrefix, HandleHealthEndpoint(serveHealth, HandleError404(&error404File, error404Verbose, http.StripPrefix("/", http.FileServer(fs)))))),
-		func() {
-			if closeFS != nil {
-				log.Printf("Closing FS watchers on " + directory)
-				closeFS.Close()
-			}
-		},
-	)
-}
+	error404File := site.Error404
+	// the handler chain is built from the inside out
+	var compressed *compressionCache
+	if site.Compression != nil {
+		compressed = newCompressionCache(site.Compression.CacheSize)
+	}
+	etags := newETagCache(site.ETagMaxSize)
+	if site.FSType != DiskFS {
+		etags.precompute(fs, docrootPath)
+		if compressed != nil {
+			compressed.precompute(site.Compression, fs, docrootPath)
+		}
+	}
 
-func startServer(wg *sync.WaitGroup, listenAddr string, tlsConfig *tls.Config, handler http.Handler, onClose func()) *http.Server {
-	server := &http.Server{Addr: listenAddr, TLSConfig: tlsConfig, Handler: handler}
-	go func() {
-		defer func() { wg.Done() }()
-		var err error
-		if tlsConfig == nil {
-			err = server.ListenAndServe()
-		} else {
-			// certFile and keyFile are empty, since the certificate is passed in the server TLSConfig
-			err = server.ListenAndServeTLS("", "")
-		}
-		if err != http.ErrServerClosed {
-			log.Printf("Encountered error: %v", err)
-		}
-		if onClose != nil {
-			onClose()
+	var handler http.Handler = http.StripPrefix("/", http.FileServer(fs))
+	handler = HandleListing(site.Listing, fs, handler)
+	handler = HandleETags(etags, fs, handler)
+	handler = HandleCompression(site.Compression, compressed, etags, fs, handler)
+	handler = HandlePrecompressed(site.Precompressed, etags, fs, handler)
+	handler = HandleSPA(site.SPA, fs, error404Verbose, handler)
+	handler = HandleError404(&error404File, site.Error404KeepStatus, error404Verbose, handler)
+	handler = HandleErrorPages(site.errorPages, fs, error404Verbose, handler)
+	// access control is applied to the requested path and again to the targets of rewrites
+	accessControl := func(h http.Handler) http.Handler {
+		h = HandleSignedURLs(site.SignedURLs, h)
+		h = HandleForwardAuth(site.ForwardAuth, h)
+		h = HandleBasicAuth(site.BasicAuth, h)
+		h = HandleOIDC(site.OIDC, h)
+		return HandleIPAccess(site.IPAccess, h)
+	}
+	handler = HandleNetlifyRules(netlify, fs, error404Verbose, func(h http.Handler) http.Handler {
+		return HandleAccessErrorPages(site.errorPages, fs, error404Verbose, accessControl, h)
+	}, handler)
+	handler = HandleHeaderRules(site.Headers, handler)
+	handler = HandleAccessErrorPages(site.errorPages, fs, error404Verbose, func(h http.Handler) http.Handler {
+		return HandleRateLimits(site.RateLimits, rateLimits, site.name(), accessControl(h))
+	}, handler)
+	handler = HandleHealthEndpoint(site.Health, handler)
+	handler = HandleCORS(site.CORS, handler)
+	handler = HandleSecurityHeaders(site.SecurityHeaders, handler)
+	handler = LogReqResponse(site.LogHeaders, logPrefix, handler)
+	handler = LogAccess(site.LogAccess, logPrefix, handler)
+	handler = HandleClientIP(site.trustedProxies, site.ForwardedHeader, handler)
+	return handler, func() {
+		if closeFS != nil {
+			log.Printf("Closing FS watchers on " + site.Directory)
+			closeFS.Close()
 		}
-	}()
-	return server
+	}, nil
 }
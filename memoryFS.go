@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/howeyc/fsnotify"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a file or directory loaded into memory
+type memoryEntry struct {
+	name string
+	mode os.FileMode
+	modTime time.Time
+	isDir bool
+	content []byte
+}
+
+func (e *memoryEntry) Name() string { return e.name }
+func (e *memoryEntry) Size() int64 { return int64(len(e.content)) }
+func (e *memoryEntry) Mode() os.FileMode { return e.mode }
+func (e *memoryEntry) ModTime() time.Time { return e.modTime }
+func (e *memoryEntry) IsDir() bool { return e.isDir }
+func (e *memoryEntry) Sys() interface{} { return nil }
+
+// memoryFilesystem eagerly loads all files of a docroot into memory and optionally watches it for changes.
+// Symlinks are followed according to the symlink policy of the docroot, like for files served from disk.
+// It replaces github.com/kamphaus/memfs, which fails on symlinked directories and can't apply a policy to symlinks.
+type memoryFilesystem struct {
+	docroot *docroot
+	verbose bool
+	lock sync.RWMutex
+	// entries are keyed by their slash separated name, / is the docroot
+	entries map[string]*memoryEntry
+	watcher *fsnotify.Watcher
+}
+
+func newMemoryFilesystem(root *docroot, watch bool, verbose bool) (*memoryFilesystem, error) {
+	fs := &memoryFilesystem{docroot: root, verbose: verbose, entries: map[string]*memoryEntry{}}
+	if watch {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return nil, err
+		}
+		fs.watcher = watcher
+		go fs.watch()
+	}
+	if _, err := os.Stat(root.root); err != nil {
+		fs.Close()
+		return nil, err
+	}
+	fs.load("/", map[string]bool{})
+	return fs, nil
+}
+
+// load loads the name and everything below it, visited are the real paths of the directories above it
+func (fs *memoryFilesystem) load(name string, visited map[string]bool) {
+	if !fs.docroot.allowed(name) {
+		fs.remove(name)
+		return
+	}
+	file := fs.docroot.path(name)
+	// Stat follows symlinks which are allowed by the policy
+	fi, err := os.Stat(file)
+	if err != nil {
+		log.Printf("Could not load %s: %v", file, err)
+		fs.remove(name)
+		return
+	}
+	entry := &memoryEntry{name: fi.Name(), mode: fi.Mode(), modTime: fi.ModTime(), isDir: fi.IsDir()}
+	if !fi.IsDir() {
+		if entry.content, err = ioutil.ReadFile(file); err != nil {
+			log.Printf("Could not load %s: %v", file, err)
+			return
+		}
+		fs.store(name, entry)
+		return
+	}
+	real, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		log.Printf("Could not load %s: %v", file, err)
+		return
+	}
+	if visited[real] {
+		log.Printf("Not loading %s again, symlinks form a cycle", file)
+		return
+	}
+	visited[real] = true
+	defer delete(visited, real)
+	fs.store(name, entry)
+	if fs.watcher != nil {
+		if err := fs.watcher.Watch(file); err != nil {
+			log.Printf("Could not watch %s: %v", file, err)
+		}
+	}
+	children, err := ioutil.ReadDir(file)
+	if err != nil {
+		log.Printf("Could not read directory %s: %v", file, err)
+		return
+	}
+	for _, child := range children {
+		fs.load(path.Join(name, child.Name()), visited)
+	}
+}
+
+func (fs *memoryFilesystem) store(name string, entry *memoryEntry) {
+	fs.lock.Lock()
+	fs.entries[name] = entry
+	fs.lock.Unlock()
+}
+
+// remove removes the name and everything below it
+func (fs *memoryFilesystem) remove(name string) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	for existing, entry := range fs.entries {
+		if existing == name || strings.HasPrefix(existing, name + "/") {
+			if entry.isDir && fs.watcher != nil {
+				fs.watcher.RemoveWatch(fs.docroot.path(existing))
+			}
+			delete(fs.entries, existing)
+		}
+	}
+}
+
+// touch updates the modification time of a directory whose entries changed
+func (fs *memoryFilesystem) touch(name string) {
+	fi, err := os.Stat(fs.docroot.path(name))
+	if err != nil {
+		return
+	}
+	fs.lock.Lock()
+	if entry, ok := fs.entries[name]; ok {
+		// entries are replaced, as open files keep using them without the lock
+		touched := *entry
+		touched.modTime = fi.ModTime()
+		fs.entries[name] = &touched
+	}
+	fs.lock.Unlock()
+}
+
+func (fs *memoryFilesystem) watch() {
+	for {
+		select {
+		case e, ok := <-fs.watcher.Event:
+			if !ok {
+				return
+			}
+			rel, err := filepath.Rel(fs.docroot.root, e.Name)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			name := path.Clean("/" + filepath.ToSlash(rel))
+			if fs.verbose {
+				log.Printf("Reloading %s", e.Name)
+			}
+			if e.IsDelete() || e.IsRename() {
+				fs.remove(name)
+			} else {
+				fs.load(name, map[string]bool{})
+			}
+			if e.IsCreate() || e.IsDelete() || e.IsRename() {
+				fs.touch(path.Dir(name))
+			}
+		case err, ok := <-fs.watcher.Error:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
+
+func (fs *memoryFilesystem) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	fs.lock.RLock()
+	entry, ok := fs.entries[name]
+	fs.lock.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &memoryFile{Reader: bytes.NewReader(entry.content), fs: fs, name: name, entry: entry}, nil
+}
+
+func (fs *memoryFilesystem) Close() error {
+	if fs.watcher == nil {
+		return nil
+	}
+	return fs.watcher.Close()
+}
+
+var errNotDir = errors.New("not a directory")
+
+type memoryFile struct {
+	*bytes.Reader
+	fs *memoryFilesystem
+	name string
+	entry *memoryEntry
+	children []os.FileInfo
+}
+
+func (f *memoryFile) Close() error {
+	return nil
+}
+
+func (f *memoryFile) Stat() (os.FileInfo, error) {
+	return f.entry, nil
+}
+
+func (f *memoryFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.entry.isDir {
+		return nil, errNotDir
+	}
+	if f.children == nil {
+		prefix := strings.TrimSuffix(f.name, "/") + "/"
+		f.children = []os.FileInfo{}
+		f.fs.lock.RLock()
+		for name, entry := range f.fs.entries {
+			if name != prefix && strings.HasPrefix(name, prefix) && !strings.Contains(name[len(prefix):], "/") {
+				f.children = append(f.children, entry)
+			}
+		}
+		f.fs.lock.RUnlock()
+		sort.Slice(f.children, func(i, j int) bool {
+			return f.children[i].Name() < f.children[j].Name()
+		})
+	}
+	if count <= 0 {
+		children := f.children
+		f.children = f.children[len(f.children):]
+		return children, nil
+	}
+	if len(f.children) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.children) {
+		count = len(f.children)
+	}
+	children := f.children[:count]
+	f.children = f.children[count:]
+	return children, nil
+}
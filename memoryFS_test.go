@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryFilesystem(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.Mkdir(tempDir + "/sub", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/sub/a.txt", []byte("a"))
+
+	fs, err := newMemoryFilesystem(newDocroot(tempDir, SymlinksWithinRoot), true, false)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	defer fs.Close()
+
+	read := func(name string) (string, error) {
+		f, err := fs.Open(name)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		content, err := ioutil.ReadAll(f)
+		return string(content), err
+	}
+	eventually := func(condition func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if condition() {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+
+	t.Run("Readdir", func(t *testing.T) {
+		f, err := fs.Open("/")
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		if len(names) != 3 || names[0] != "sub" || names[1] != "test.js" || names[2] != "test.txt" {
+			t.Fatalf("Expected %v but got %v", []string{"sub", "test.js", "test.txt"}, names)
+		}
+	})
+
+	t.Run("Modified file is reloaded", func(t *testing.T) {
+		writeFile(tempDir + "/sub/a.txt", []byte("changed"))
+		if !eventually(func() bool { content, _ := read("/sub/a.txt"); return content == "changed" }) {
+			t.Fatalf("Expected the modified file to be reloaded")
+		}
+	})
+
+	t.Run("New directory is loaded", func(t *testing.T) {
+		if err := os.Mkdir(tempDir + "/new", 0755); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if !eventually(func() bool { _, err := fs.Open("/new"); return err == nil }) {
+			t.Fatalf("Expected the new directory to be loaded")
+		}
+		writeFile(tempDir + "/new/b.txt", []byte("b"))
+		if !eventually(func() bool { content, _ := read("/new/b.txt"); return content == "b" }) {
+			t.Fatalf("Expected the file in the new directory to be loaded")
+		}
+	})
+
+	t.Run("Removed symlink to a directory is removed with its files", func(t *testing.T) {
+		if err := os.Symlink(tempDir + "/new", tempDir + "/linked"); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if !eventually(func() bool { content, _ := read("/linked/b.txt"); return content == "b" }) {
+			t.Fatalf("Expected the files of the symlinked directory to be loaded")
+		}
+		if err := os.Remove(tempDir + "/linked"); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if !eventually(func() bool { _, err := fs.Open("/linked/b.txt"); return os.IsNotExist(err) }) {
+			t.Fatalf("Expected the files of the removed symlink to be removed")
+		}
+	})
+
+	t.Run("Deleted directory is removed", func(t *testing.T) {
+		if err := os.RemoveAll(tempDir + "/sub"); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if !eventually(func() bool { _, err := fs.Open("/sub/a.txt"); return os.IsNotExist(err) }) {
+			t.Fatalf("Expected the deleted file to be removed")
+		}
+	})
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/felixge/httpsnoop"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Netlify style rule files in the docroot, they are never served
+const (
+	headersFile = "/_headers"
+	redirectsFile = "/_redirects"
+)
+
+// isRuleFile returns whether the name is one of the rule files which must not be served
+func isRuleFile(name string) bool {
+	name = path.Clean("/" + name)
+	return name == headersFile || name == redirectsFile
+}
+
+// pathPattern matches URL paths like /news/:year/:slug or /shop/*, trailing slashes are ignored
+type pathPattern struct {
+	segments []string
+	// splat is set for patterns ending with /*, which match the remaining path
+	splat bool
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func parsePathPattern(p string) (*pathPattern, error) {
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("path %s must start with /", p)
+	}
+	pattern := &pathPattern{segments: splitPath(p)}
+	if n := len(pattern.segments); n > 0 && pattern.segments[n-1] == "*" {
+		pattern.segments = pattern.segments[:n-1]
+		pattern.splat = true
+	}
+	return pattern, nil
+}
+
+// match returns the values of the placeholders and the splat, if the path matches
+func (p *pathPattern) match(urlPath string, params map[string]string) bool {
+	parts := splitPath(urlPath)
+	if len(parts) < len(p.segments) || len(parts) > len(p.segments) && !p.splat {
+		return false
+	}
+	for i, segment := range p.segments {
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = parts[i]
+		} else if segment != parts[i] {
+			return false
+		}
+	}
+	if p.splat {
+		params["splat"] = strings.Join(parts[len(p.segments):], "/")
+	}
+	return true
+}
+
+// expandPlaceholders replaces :name in the target by the matched values, unknown names are kept
+func expandPlaceholders(target string, params map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(target); i++ {
+		if target[i] != ':' {
+			b.WriteByte(target[i])
+			continue
+		}
+		j := i + 1
+		for j < len(target) && isNameChar(target[j]) {
+			j++
+		}
+		if value, ok := params[target[i+1:j]]; ok && j > i+1 {
+			b.WriteString(value)
+			i = j - 1
+		} else {
+			b.WriteByte(':')
+		}
+	}
+	return b.String()
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// redirectRule is a line of a _redirects file like "/blog/* /news/:splat 301!"
+type redirectRule struct {
+	from *pathPattern
+	// query are required query parameters, values starting with : are placeholders
+	query map[string]string
+	to string
+	status int
+	// force applies the rule even if a file exists for the path
+	force bool
+}
+
+func parseRedirects(r io.Reader) ([]*redirectRule, []error) {
+	var rules []*redirectRule
+	var errs []error
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule, err := parseRedirect(fields)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", line, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return rules, errs
+}
+
+func parseRedirect(fields []string) (*redirectRule, error) {
+	from, err := parsePathPattern(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	rule := &redirectRule{from: from, query: map[string]string{}, status: http.StatusMovedPermanently}
+	fields = fields[1:]
+	// query conditions like id=:id come before the target, which is a path or a URL
+	for len(fields) > 0 && strings.Contains(fields[0], "=") && !strings.HasPrefix(fields[0], "/") && !strings.Contains(fields[0], "://") {
+		kv := strings.SplitN(fields[0], "=", 2)
+		rule.query[kv[0]] = kv[1]
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no target")
+	}
+	rule.to = fields[0]
+	if len(fields) > 1 {
+		status := fields[1]
+		if strings.HasSuffix(status, "!") {
+			rule.force = true
+			status = strings.TrimSuffix(status, "!")
+		}
+		if rule.status, err = strconv.Atoi(status); err != nil || http.StatusText(rule.status) == "" {
+			return nil, fmt.Errorf("invalid status %s", fields[1])
+		}
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("unsupported conditions %s", strings.Join(fields[2:], " "))
+	}
+	isRedirect := rule.status >= 300 && rule.status < 400
+	if !isRedirect && !strings.HasPrefix(rule.to, "/") {
+		return nil, fmt.Errorf("status %d requires a path in the docroot, proxying is not supported", rule.status)
+	}
+	if !isRedirect && rule.status != http.StatusOK && rule.status < 400 {
+		return nil, fmt.Errorf("unsupported status %d", rule.status)
+	}
+	return rule, nil
+}
+
+// match returns the expanded target, if the request matches the rule
+func (rule *redirectRule) match(r *http.Request) (string, bool) {
+	params := map[string]string{}
+	if !rule.from.match(r.URL.Path, params) {
+		return "", false
+	}
+	query := r.URL.Query()
+	for name, value := range rule.query {
+		actual := query.Get(name)
+		if actual == "" {
+			return "", false
+		}
+		if strings.HasPrefix(value, ":") {
+			params[value[1:]] = actual
+		} else if value != actual {
+			return "", false
+		}
+	}
+	return expandPlaceholders(rule.to, params), true
+}
+
+// headerBlock is a path of a _headers file with the headers indented below it
+type headerBlock struct {
+	path *pathPattern
+	header http.Header
+}
+
+func parseHeaders(r io.Reader) ([]*headerBlock, []error) {
+	var blocks []*headerBlock
+	var errs []error
+	var block *headerBlock
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if text[0] != ' ' && text[0] != '\t' {
+			block = nil
+			p, err := parsePathPattern(trimmed)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %v", line, err))
+				continue
+			}
+			block = &headerBlock{path: p, header: http.Header{}}
+			blocks = append(blocks, block)
+			continue
+		}
+		i := strings.Index(trimmed, ":")
+		if block == nil || i <= 0 {
+			errs = append(errs, fmt.Errorf("line %d: expected a path or an indented header", line))
+			continue
+		}
+		block.header.Add(strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:]))
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return blocks, errs
+}
+
+// logRuleErrors logs the invalid rules of a file, the valid rules are still applied
+func logRuleErrors(name string, errs []error) {
+	for _, err := range errs {
+		log.Printf("Ignoring invalid rule in %s: %v", name, err)
+	}
+}
+
+// loadRules returns the parsed rules of the file, a missing file has no rules
+func loadRules(f *reloadableFile) interface{} {
+	rules, err := f.load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not read %s: %v", f.name, err)
+	}
+	return rules
+}
+
+// netlifyCheckInterval is how often the rule files are checked for changes
+const netlifyCheckInterval = time.Second
+
+// netlifyRules are the rules of the _headers and _redirects files of a docroot,
+// they are reloaded when the files change
+type netlifyRules struct {
+	checkInterval time.Duration
+	// lastCheck is the time in unix nanoseconds when the files were last checked
+	lastCheck int64
+	// snapshot holds the *netlifySnapshot of the last check, requests read it without the lock
+	snapshot atomic.Value
+	// lock is held while the files are checked and parsed
+	lock sync.Mutex
+	headers *reloadableFile
+	redirects *reloadableFile
+}
+
+type netlifySnapshot struct {
+	blocks []*headerBlock
+	redirects []*redirectRule
+}
+
+// newNetlifyRules reads the rule files from the fs, which must not hide them
+func newNetlifyRules(fs http.FileSystem) *netlifyRules {
+	return &netlifyRules{
+		checkInterval: netlifyCheckInterval,
+		headers: &reloadableFile{name: headersFile, open: fs.Open, parse: func(r io.Reader) (interface{}, error) {
+			blocks, errs := parseHeaders(r)
+			logRuleErrors(headersFile, errs)
+			return blocks, nil
+		}},
+		redirects: &reloadableFile{name: redirectsFile, open: fs.Open, parse: func(r io.Reader) (interface{}, error) {
+			rules, errs := parseRedirects(r)
+			logRuleErrors(redirectsFile, errs)
+			return rules, nil
+		}},
+	}
+}
+
+// load returns the rules of the last check, the files are checked at most every checkInterval by a single request
+func (n *netlifyRules) load() ([]*headerBlock, []*redirectRule) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&n.lastCheck)
+	snapshot, ok := n.snapshot.Load().(*netlifySnapshot)
+	if ok && (now - last < int64(n.checkInterval) || !atomic.CompareAndSwapInt64(&n.lastCheck, last, now)) {
+		return snapshot.blocks, snapshot.redirects
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !ok {
+		// the first requests wait for the files to be parsed once
+		if snapshot, ok = n.snapshot.Load().(*netlifySnapshot); ok {
+			return snapshot.blocks, snapshot.redirects
+		}
+		atomic.StoreInt64(&n.lastCheck, now)
+	}
+	blocks, _ := loadRules(n.headers).([]*headerBlock)
+	rules, _ := loadRules(n.redirects).([]*redirectRule)
+	n.snapshot.Store(&netlifySnapshot{blocks: blocks, redirects: rules})
+	return blocks, rules
+}
+
+// exists returns whether the path is served by a file or a directory index
+func exists(fs http.FileSystem, urlPath string) bool {
+	return isFile(fs, urlPath) || isFile(fs, path.Join(urlPath, "index.html"))
+}
+
+// HandleNetlifyRules applies the _headers and _redirects files of the docroot.
+// Headers of all matching paths are set (later paths override earlier ones), the first matching redirect rule is applied.
+// Unless forced with !, redirect rules only apply to paths for which no file exists.
+// Status 200 rewrites the request to the target, 4xx serves the target with that status.
+// Rewritten requests pass accessControl (if set) again, so that a rewrite can't serve protected targets.
+func HandleNetlifyRules(rules *netlifyRules, fs http.FileSystem, shouldLog bool, accessControl func(http.Handler) http.Handler, h http.Handler) http.Handler {
+	rewritten := h
+	if accessControl != nil {
+		rewritten = accessControl(h)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blocks, redirects := rules.load()
+		for _, block := range blocks {
+			if block.path.match(r.URL.Path, map[string]string{}) {
+				for name, values := range block.header {
+					w.Header()[name] = append([]string{}, values...)
+				}
+			}
+		}
+		for _, rule := range redirects {
+			target, ok := rule.match(r)
+			if !ok || !rule.force && exists(fs, r.URL.Path) {
+				continue
+			}
+			if shouldLog {
+				log.Printf("Redirect rule for %s: %s %d", r.URL.Path, target, rule.status)
+			}
+			if rule.status >= 300 && rule.status < 400 {
+				if !strings.Contains(target, "?") && r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, rule.status)
+				return
+			}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			if i := strings.Index(target, "?"); i >= 0 {
+				target, r2.URL.RawQuery = target[:i], target[i+1:]
+			}
+			// http.FileServer redirects requests for index.html to the directory
+			if strings.HasSuffix(target, "/index.html") {
+				target = strings.TrimSuffix(target, "index.html")
+			}
+			r2.URL.Path = target
+			r2.URL.RawPath = ""
+			r2.RequestURI = r2.URL.RequestURI()
+			if rule.status == http.StatusOK {
+				rewritten.ServeHTTP(w, r2)
+				return
+			}
+			r2.Header = r.Header.Clone()
+			for _, header := range conditionalHeaders {
+				r2.Header.Del(header)
+			}
+			rewritten.ServeHTTP(httpsnoop.Wrap(w, keepStatusHooks(w, rule.status)), r2)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
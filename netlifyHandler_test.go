@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNetlifyHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.MkdirAll(tempDir + "/app", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/app/index.html", []byte("<html>App</html>"))
+	writeFile(tempDir + "/404.html", []byte("Custom not found"))
+	writeFile(tempDir + "/_headers", []byte("/app/*\n  X-Frame-Options: DENY\n  Cache-Control: no-cache\n# comment\n/test.txt\n  X-Text: yes\n"))
+	writeFile(tempDir + "/_redirects", []byte(`# redirects
+/old/:year/:slug  /news/:year/:slug  301
+/shop/*           /store/:splat      302
+/search id=:id    /results/:id
+/test.txt         /404.html          200
+/forced           /test.txt          200!
+/app/*            /app/index.html    200
+/gone             /404.html          410
+/invalid          /x                 999
+`))
+
+	rules := newNetlifyRules(http.Dir(tempDir))
+	rules.checkInterval = 100 * time.Millisecond
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandleNetlifyRules(rules, fs, false, nil, http.FileServer(fs))
+
+	tests := []struct {
+		name string
+		URL string
+		code int
+		location string
+		shouldContain string
+		header string
+		headerValue string
+	}{
+		{name: "Placeholders", URL: "/old/2020/hello", code: http.StatusMovedPermanently, location: "/news/2020/hello"},
+		{name: "Splat keeps query", URL: "/shop/shoes/red?size=42", code: http.StatusFound, location: "/store/shoes/red?size=42"},
+		{name: "Query placeholder", URL: "/search?id=7", code: http.StatusMovedPermanently, location: "/results/7?id=7"},
+		{name: "Missing query parameter", URL: "/search", code: http.StatusNotFound},
+		{name: "Existing file shadows rule", URL: "/test.txt", code: http.StatusOK, shouldContain: "hello go", header: "X-Text", headerValue: "yes"},
+		{name: "Forced rewrite", URL: "/forced", code: http.StatusOK, shouldContain: "hello go"},
+		{name: "Rewrite with headers", URL: "/app/users/42", code: http.StatusOK, shouldContain: "App", header: "X-Frame-Options", headerValue: "DENY"},
+		{name: "Status rewrite", URL: "/gone", code: http.StatusGone, shouldContain: "Custom not found"},
+		{name: "Invalid rule is ignored", URL: "/invalid", code: http.StatusNotFound},
+		{name: "Redirects file is hidden", URL: "/_redirects", code: http.StatusNotFound},
+		{name: "Headers file is hidden", URL: "/./_headers", code: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			if rec.Header().Get("Location") != test.location {
+				t.Fatalf("Expected Location %v but got %v", test.location, rec.Header().Get("Location"))
+			}
+			if body := rec.Body.String(); !strings.Contains(body, test.shouldContain) {
+				t.Fatalf("%v should contain %v", body, test.shouldContain)
+			}
+			if test.header != "" && rec.Header().Get(test.header) != test.headerValue {
+				t.Fatalf("Expected %s %v but got %v", test.header, test.headerValue, rec.Header().Get(test.header))
+			}
+		})
+	}
+
+	t.Run("Reloaded on change", func(t *testing.T) {
+		writeFile(tempDir + "/_redirects", []byte("/old/* /archive/:splat 308\n"))
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(tempDir + "/_redirects", later, later); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		// starts a new interval, as if the files were just checked
+		atomic.StoreInt64(&rules.lastCheck, time.Now().UnixNano())
+		r := httptest.NewRequest("GET", "/old/2020/hello", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusMovedPermanently {
+			t.Fatalf("Expected the files not to be checked again within the interval but got %v", rec.Code)
+		}
+		time.Sleep(rules.checkInterval)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusPermanentRedirect {
+			t.Fatalf("Expected %v but got %v", http.StatusPermanentRedirect, rec.Code)
+		}
+		if rec.Header().Get("Location") != "/archive/2020/hello" {
+			t.Fatalf("Expected Location %v but got %v", "/archive/2020/hello", rec.Header().Get("Location"))
+		}
+	})
+}
+
+func TestNetlifyRewritesPassAccessControl(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	if err := os.MkdirAll(tempDir + "/private", 0755); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	if err := os.MkdirAll(tempDir + "/office", 0755); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/private/secret.txt", []byte("SECRET"))
+	writeFile(tempDir + "/office/plan.txt", []byte("PLAN"))
+	writeFile(tempDir + "/_redirects", []byte("/public/* /private/:splat 200\n/gone/* /private/:splat 410\n/forced /private/secret.txt 200!\n/lobby/* /office/:splat 200\n"))
+	htpasswd := setupFS()
+	defer cleanTempDir(htpasswd)
+	// alice:secret
+	writeFile(htpasswd + "/users", []byte("alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"))
+
+	cfg, err := parseConfig([]byte(`
+sites:
+  - port: "8100"
+    directory: ` + tempDir + `
+    basicAuth:
+      - path: /private
+        htpasswd: ` + htpasswd + `/users
+    ipAccess:
+      - path: /office
+        allow: [10.0.0.0/8]
+`))
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	h, closeFS, err := serve(&cfg.Sites[0], 1, false, newRateLimitStore())
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	defer closeFS()
+
+	tests := []struct {
+		name string
+		URL string
+		auth bool
+		code int
+	}{
+		{name: "Protected path", URL: "/private/secret.txt", code: http.StatusUnauthorized},
+		{name: "Protected path with credentials", URL: "/private/secret.txt", auth: true, code: http.StatusOK},
+		{name: "Rewrite to protected path", URL: "/public/secret.txt", code: http.StatusUnauthorized},
+		{name: "Rewrite to protected path with credentials", URL: "/public/secret.txt", auth: true, code: http.StatusOK},
+		{name: "Status rewrite to protected path", URL: "/gone/secret.txt", code: http.StatusUnauthorized},
+		{name: "Forced rewrite to protected path", URL: "/forced", code: http.StatusUnauthorized},
+		{name: "Rewrite to address restricted path", URL: "/lobby/plan.txt", code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			r.RemoteAddr = "198.51.100.7:1234"
+			if test.auth {
+				r.SetBasicAuth("alice", "secret")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			if rec.Code != http.StatusOK && strings.Contains(rec.Body.String(), "SECRET") || strings.Contains(rec.Body.String(), "PLAN") {
+				t.Fatalf("Expected the protected file not to be served but got %v", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcProvider is an OpenID Connect issuer whose metadata and keys are fetched on first use
+type oidcProvider struct {
+	issuer string
+	client *http.Client
+	lock sync.Mutex
+	metadata *oidcMetadata
+	keys map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// oidcMetadata is the part of the discovery document which is used
+type oidcMetadata struct {
+	Issuer string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI string `json:"jwks_uri"`
+	EndSessionEndpoint string `json:"end_session_endpoint"`
+}
+
+// jwksRefreshInterval limits how often the keys are fetched again for an unknown key id
+const jwksRefreshInterval = time.Minute
+
+func newOIDCProvider(issuer string) *oidcProvider {
+	return &oidcProvider{issuer: issuer, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *oidcProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("%s answered %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover returns the metadata of the issuer, it's fetched again until it was fetched successfully
+func (p *oidcProvider) discover() (*oidcMetadata, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	var metadata oidcMetadata
+	if err := p.getJSON(strings.TrimSuffix(p.issuer, "/") + "/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != p.issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document lacks an authorization, token or jwks endpoint")
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// jsonWebKey is a public key of a JWKS, RSA and EC P-256 keys are supported
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N string `json:"n"`
+	E string `json:"e"`
+	Crv string `json:"crv"`
+	X string `json:"x"`
+	Y string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1 << 31 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// key returns the signing key with the id, the keys are fetched again if it's unknown as the issuer may have rotated them
+func (p *oidcProvider) key(kid string) (crypto.PublicKey, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(metadata.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	p.keysFetched = time.Now()
+	p.keys = map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = key
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %s", kid)
+}
+
+// exchange redeems the authorization code at the token endpoint and returns the ID token
+func (p *oidcProvider) exchange(config *OIDCConfig, code string, verifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"authorization_code"},
+		"code": {code},
+		"redirect_uri": {config.RedirectURL},
+		"client_id": {config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("token endpoint answered %d: %v", resp.StatusCode, err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("token endpoint answered %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", fmt.Errorf("token endpoint answered %d without an id token", resp.StatusCode)
+	}
+	return token.IDToken, nil
+}
+
+var errInvalidToken = errors.New("invalid id token")
+
+// verify checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims
+func (p *oidcProvider) verify(token string, clientID string, nonce string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("invalid %s signature", header.Alg)
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, fmt.Errorf("invalid %s signature", header.Alg)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", header.Alg)
+	}
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("id token issued by %s", iss)
+	}
+	if !containsString(stringsClaim(claims, "aud"), clientID) {
+		return nil, fmt.Errorf("id token not issued for %s", clientID)
+	}
+	// a minute of clock skew between the issuer and static-serve is accepted
+	if exp, _ := claims["exp"].(float64); now.Add(-time.Minute).After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("id token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("id token has the wrong nonce")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errInvalidToken
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errInvalidToken
+	}
+	return nil
+}
+
+// stringsClaim returns a claim which is a string or an array of strings
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// OIDCConfig requires an OpenID Connect login with the authorization code flow and PKCE
+type OIDCConfig struct {
+	// Issuer is the URL of the OpenID provider, its configuration is discovered below /.well-known/openid-configuration
+	Issuer string `yaml:"issuer"`
+	ClientID string `yaml:"clientID"`
+	// ClientSecret is sent to the token endpoint, it's optional for public clients
+	ClientSecret string `yaml:"clientSecret"`
+	// RedirectURL is the absolute URL of the callback, e.g. https://docs.example.com/oauth2/callback
+	RedirectURL string `yaml:"redirectURL"`
+	// Scopes are requested in addition to openid (default: email profile)
+	Scopes []string `yaml:"scopes"`
+	// CookieSecret encrypts the session cookie, it must be at least 32 characters long
+	CookieSecret string `yaml:"cookieSecret"`
+	// CookieName is the name of the session cookie (default: static_serve_session)
+	CookieName string `yaml:"cookieName"`
+	// SessionDuration is how long a login is valid (default: 8h)
+	SessionDuration time.Duration `yaml:"sessionDuration"`
+	// Path is the path prefix which requires a login (default: /)
+	Path string `yaml:"path"`
+	// LogoutPath ends the session (default: /oauth2/logout)
+	LogoutPath string `yaml:"logoutPath"`
+	// PostLogoutRedirectURL is where the provider sends users after they logged out
+	PostLogoutRedirectURL string `yaml:"postLogoutRedirectURL"`
+	// EmailClaim and GroupsClaim are the claims of the ID token matched by Allow (default: email and groups)
+	EmailClaim string `yaml:"emailClaim"`
+	GroupsClaim string `yaml:"groupsClaim"`
+	// Allow restricts path prefixes to users with the listed emails or groups, the longest matching prefix applies
+	Allow []OIDCAllowRule `yaml:"allow"`
+	provider *oidcProvider
+	callbackPath string
+	secure bool
+	aead cipher.AEAD
+}
+
+// OIDCAllowRule allows the users with one of the emails or groups to access a path prefix
+type OIDCAllowRule struct {
+	Path string `yaml:"path"`
+	// Emails are email addresses or domains starting with @
+	Emails []string `yaml:"emails"`
+	Groups []string `yaml:"groups"`
+}
+
+func (c *OIDCConfig) finalize() error {
+	if c.Issuer == "" || c.ClientID == "" {
+		return fmt.Errorf("issuer and clientID are required")
+	}
+	redirect, err := url.Parse(c.RedirectURL)
+	if err != nil {
+		return err
+	}
+	if redirect.Scheme != "http" && redirect.Scheme != "https" || redirect.Host == "" {
+		return fmt.Errorf("redirectURL %s must be an absolute http or https url", c.RedirectURL)
+	}
+	if len(c.CookieSecret) < 32 {
+		return fmt.Errorf("cookieSecret must be at least 32 characters long")
+	}
+	if c.Scopes == nil {
+		c.Scopes = []string{"email", "profile"}
+	}
+	if c.CookieName == "" {
+		c.CookieName = "static_serve_session"
+	}
+	if c.SessionDuration == 0 {
+		c.SessionDuration = 8 * time.Hour
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.LogoutPath == "" {
+		c.LogoutPath = "/oauth2/logout"
+	}
+	if c.EmailClaim == "" {
+		c.EmailClaim = "email"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	for _, p := range append([]string{c.Path, c.LogoutPath}, allowRulePaths(c.Allow)...) {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("path %s must start with /", p)
+		}
+	}
+	c.callbackPath = path.Clean("/" + redirect.Path)
+	c.secure = redirect.Scheme == "https"
+	key := sha256.Sum256([]byte(c.CookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	if c.aead, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+	c.provider = newOIDCProvider(c.Issuer)
+	return nil
+}
+
+func allowRulePaths(rules []OIDCAllowRule) []string {
+	var paths []string
+	for _, rule := range rules {
+		paths = append(paths, rule.Path)
+	}
+	return paths
+}
+
+// oidcSession is stored encrypted in the session cookie
+type oidcSession struct {
+	Subject string `json:"sub"`
+	Email string `json:"email"`
+	Groups []string `json:"groups"`
+	Expires int64 `json:"exp"`
+}
+
+// oidcLogin is stored encrypted in a cookie while the user logs in at the provider
+type oidcLogin struct {
+	State string `json:"state"`
+	Nonce string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Return string `json:"return"`
+	Expires int64 `json:"exp"`
+}
+
+const oidcLoginDuration = 10 * time.Minute
+
+// setCookie encrypts the value into a cookie, the cookie name is authenticated so cookies can't be swapped
+func (c *OIDCConfig) setCookie(w http.ResponseWriter, name string, value interface{}, maxAge time.Duration) error {
+	plain, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plain, []byte(name))
+	http.SetCookie(w, &http.Cookie{
+		Name: name,
+		Value: base64.RawURLEncoding.EncodeToString(sealed),
+		Path: "/",
+		MaxAge: int(maxAge.Seconds()),
+		Secure: c.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// readCookie decrypts the cookie into the value and returns whether it was valid
+func (c *OIDCConfig) readCookie(r *http.Request, name string, value interface{}) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return false
+	}
+	plain, err := c.aead.Open(nil, sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():], []byte(name))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(plain, value) == nil
+}
+
+func (c *OIDCConfig) deleteCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, Secure: c.secure, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+}
+
+func (c *OIDCConfig) session(r *http.Request) (*oidcSession, bool) {
+	var session oidcSession
+	if !c.readCookie(r, c.CookieName, &session) || time.Now().Unix() > session.Expires {
+		return nil, false
+	}
+	return &session, true
+}
+
+// allows returns whether the longest allow rule matching the path allows the user, all users are allowed without a rule
+func (c *OIDCConfig) allows(session *oidcSession, cleanPath string) bool {
+	var rule *OIDCAllowRule
+	for i := range c.Allow {
+		if belowPath(c.Allow[i].Path, cleanPath) && (rule == nil || len(c.Allow[i].Path) > len(rule.Path)) {
+			rule = &c.Allow[i]
+		}
+	}
+	if rule == nil || len(rule.Emails) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+	email := strings.ToLower(session.Email)
+	for _, allowed := range rule.Emails {
+		allowed = strings.ToLower(allowed)
+		if email != "" && (email == allowed || strings.HasPrefix(allowed, "@") && strings.HasSuffix(email, allowed)) {
+			return true
+		}
+	}
+	for _, group := range session.Groups {
+		if containsString(rule.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// login sends the user to the provider, the request is resumed after the callback
+func (c *OIDCConfig) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	metadata, err := c.provider.discover()
+	if err != nil {
+		log.Printf("Could not discover the OpenID provider %s: %v", c.Issuer, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	login := oidcLogin{Return: r.URL.RequestURI(), Expires: time.Now().Add(oidcLoginDuration).Unix()}
+	for _, token := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		if *token, err = randomToken(); err != nil {
+			log.Printf("Could not start a login: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := c.setCookie(w, c.CookieName + "_login", login, oidcLoginDuration); err != nil {
+		log.Printf("Could not start a login: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id": {c.ClientID},
+		"redirect_uri": {c.RedirectURL},
+		"scope": {strings.Join(append([]string{"openid"}, c.Scopes...), " ")},
+		"state": {login.State},
+		"nonce": {login.Nonce},
+		"code_challenge": {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, metadata.AuthorizationEndpoint + separator + query.Encode(), http.StatusFound)
+}
+
+// callback redeems the authorization code and stores the claims of the ID token in the session cookie
+func (c *OIDCConfig) callback(w http.ResponseWriter, r *http.Request) {
+	var login oidcLogin
+	if !c.readCookie(r, c.CookieName + "_login", &login) || time.Now().Unix() > login.Expires {
+		http.Error(w, "Login expired, please retry", http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		http.Error(w, "Invalid login state, please retry", http.StatusBadRequest)
+		return
+	}
+	if e := query.Get("error"); e != "" {
+		log.Printf("Login at %s failed: %s %s", c.Issuer, e, query.Get("error_description"))
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	token, err := c.provider.exchange(c, query.Get("code"), login.Verifier)
+	if err != nil {
+		log.Printf("Could not redeem the authorization code at %s: %v", c.Issuer, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	claims, err := c.provider.verify(token, c.ClientID, login.Nonce, time.Now())
+	if err != nil {
+		log.Printf("Rejected id token of %s: %v", c.Issuer, err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	session := oidcSession{Groups: stringsClaim(claims, c.GroupsClaim), Expires: time.Now().Add(c.SessionDuration).Unix()}
+	session.Subject, _ = claims["sub"].(string)
+	if verified, ok := claims["email_verified"].(bool); !ok || verified {
+		session.Email, _ = claims[c.EmailClaim].(string)
+	}
+	if err := c.setCookie(w, c.CookieName, session, c.SessionDuration); err != nil {
+		log.Printf("Could not store the session: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	c.deleteCookie(w, c.CookieName + "_login")
+	target := login.Return
+	// only local paths are returned to, // would be another host
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		target = "/"
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// logout deletes the session cookie and ends the session at the provider if it supports it
+func (c *OIDCConfig) logout(w http.ResponseWriter, r *http.Request) {
+	c.deleteCookie(w, c.CookieName)
+	target := "/"
+	if metadata, err := c.provider.discover(); err == nil && metadata.EndSessionEndpoint != "" {
+		query := url.Values{"client_id": {c.ClientID}}
+		if c.PostLogoutRedirectURL != "" {
+			query.Set("post_logout_redirect_uri", c.PostLogoutRedirectURL)
+		}
+		separator := "?"
+		if strings.Contains(metadata.EndSessionEndpoint, "?") {
+			separator = "&"
+		}
+		target = metadata.EndSessionEndpoint + separator + query.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// HandleOIDC requires an OpenID Connect login for the protected path prefix and answers
+// the callback and logout paths. The allow rules decide which users may access a path, others get a 403.
+func HandleOIDC(config *OIDCConfig, h http.Handler) http.Handler {
+	if config == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleanPath := path.Clean("/" + r.URL.Path)
+		switch cleanPath {
+		case config.callbackPath:
+			config.callback(w, r)
+			return
+		case path.Clean(config.LogoutPath):
+			config.logout(w, r)
+			return
+		}
+		if !belowPath(config.Path, cleanPath) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		session, ok := config.session(r)
+		if !ok {
+			config.login(w, r)
+			return
+		}
+		user := session.Email
+		if user == "" {
+			user = session.Subject
+		}
+		addAccessLogField(r, "user", user)
+		if !config.allows(session, cleanPath) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// oidcClient follows the redirects of a login like a browser, keeping the cookies set by the handler
+type oidcClient struct {
+	t *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (c *oidcClient) get(target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	for _, cookie := range c.cookies {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, r)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return rec
+}
+
+// login follows the login at the issuer and returns the response to the callback
+func (c *oidcClient) login(target string) *httptest.ResponseRecorder {
+	rec := c.get(target)
+	if rec.Code != http.StatusFound {
+		c.t.Fatalf("Expected %v but got %v", http.StatusFound, rec.Code)
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		c.t.Fatalf("expected no error got %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		c.t.Fatalf("expected no error got %v", err)
+	}
+	return c.get(callback.RequestURI())
+}
+
+func TestOIDCHandler(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.server.Close()
+	config := &OIDCConfig{
+		Issuer: issuer.server.URL,
+		ClientID: "docs",
+		ClientSecret: "secret",
+		RedirectURL: "https://docs.example.com/oauth2/callback",
+		CookieSecret: "0123456789abcdef0123456789abcdef",
+		Path: "/internal",
+		PostLogoutRedirectURL: "https://docs.example.com/",
+		Allow: []OIDCAllowRule{
+			{Path: "/internal/hr", Groups: []string{"hr"}},
+			{Path: "/internal/eng", Emails: []string{"lead@example.org", "@example.com"}},
+		},
+	}
+	if err := config.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	h := LogAccess(true, "", HandleOIDC(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	})))
+
+	t.Run("Unprotected path", func(t *testing.T) {
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		if rec := c.get("/public/"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("Login redirect", func(t *testing.T) {
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		rec := c.get("/internal/doc.html")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected %v but got %v", http.StatusFound, rec.Code)
+		}
+		location, _ := url.Parse(rec.Header().Get("Location"))
+		q := location.Query()
+		expected := map[string]string{"response_type": "code", "client_id": "docs", "redirect_uri": config.RedirectURL, "scope": "openid email profile", "code_challenge_method": "S256"}
+		for name, value := range expected {
+			if q.Get(name) != value {
+				t.Fatalf("Expected %v %v but got %v", name, value, q.Get(name))
+			}
+		}
+		if q.Get("state") == "" || q.Get("nonce") == "" || q.Get("code_challenge") == "" {
+			t.Fatalf("Expected a state, nonce and code challenge in %v", location)
+		}
+		cookie := c.cookies["static_serve_session_login"]
+		if cookie == nil || !cookie.Secure || !cookie.HttpOnly || strings.Contains(cookie.Value, q.Get("state")) {
+			t.Fatalf("Expected an encrypted secure login cookie but got %v", cookie)
+		}
+	})
+
+	t.Run("Login and access", func(t *testing.T) {
+		issuer.setClaims(map[string]interface{}{"sub": "1", "email": "dev@example.com", "groups": []string{"eng"}})
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		rec := c.login("/internal/eng/doc.html?page=2")
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/internal/eng/doc.html?page=2" {
+			t.Fatalf("Expected a redirect to the requested page but got %v %v", rec.Code, rec.Header().Get("Location"))
+		}
+		if c.cookies["static_serve_session_login"] != nil || c.cookies["static_serve_session"] == nil {
+			t.Fatalf("Expected the login cookie to be replaced by a session cookie but got %v", c.cookies)
+		}
+		l := log.Writer()
+		buf := &bytes.Buffer{}
+		log.SetOutput(buf)
+		defer log.SetOutput(l)
+		rec = c.get("/internal/eng/doc.html?page=2")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+		}
+		if !strings.Contains(buf.String(), `user="dev@example.com"`) {
+			t.Fatalf("%v should contain %v", buf.String(), `user="dev@example.com"`)
+		}
+		if rec = c.get("/internal/hr/salaries.html"); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected %v but got %v", http.StatusForbidden, rec.Code)
+		}
+		if rec = c.get("/internal/other.html"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+		}
+
+		rec = c.get("/oauth2/logout")
+		location, _ := url.Parse(rec.Header().Get("Location"))
+		if rec.Code != http.StatusFound || location.Path != "/logout" || location.Query().Get("post_logout_redirect_uri") != "https://docs.example.com/" {
+			t.Fatalf("Expected a redirect to the end session endpoint but got %v %v", rec.Code, location)
+		}
+		if c.cookies["static_serve_session"] != nil {
+			t.Fatalf("Expected the session cookie to be deleted")
+		}
+		if rec = c.get("/internal/other.html"); rec.Code != http.StatusFound {
+			t.Fatalf("Expected %v but got %v", http.StatusFound, rec.Code)
+		}
+	})
+
+	t.Run("Group allow-list", func(t *testing.T) {
+		issuer.setClaims(map[string]interface{}{"sub": "2", "email": "hr@example.org", "groups": []string{"hr"}})
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		c.login("/internal/hr/")
+		if rec := c.get("/internal/hr/salaries.html"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+		}
+		if rec := c.get("/internal/eng/"); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected %v but got %v", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("Unverified email", func(t *testing.T) {
+		issuer.setClaims(map[string]interface{}{"sub": "3", "email": "lead@example.org", "email_verified": false})
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		c.login("/internal/eng/")
+		if rec := c.get("/internal/eng/"); rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected %v but got %v", http.StatusForbidden, rec.Code)
+		}
+	})
+
+	t.Run("Forged state", func(t *testing.T) {
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		c.get("/internal/")
+		if rec := c.get("/oauth2/callback?code=abc&state=forged"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected %v but got %v", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	t.Run("Forged session", func(t *testing.T) {
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		c.cookies["static_serve_session"] = &http.Cookie{Name: "static_serve_session", Value: "eyJzdWIiOiIxIn0"}
+		if rec := c.get("/internal/"); rec.Code != http.StatusFound {
+			t.Fatalf("Expected %v but got %v", http.StatusFound, rec.Code)
+		}
+	})
+
+	t.Run("Login cookie is no session", func(t *testing.T) {
+		c := &oidcClient{t: t, handler: h, cookies: map[string]*http.Cookie{}}
+		c.get("/internal/")
+		login := *c.cookies["static_serve_session_login"]
+		login.Name = "static_serve_session"
+		c.cookies["static_serve_session"] = &login
+		if rec := c.get("/internal/"); rec.Code != http.StatusFound {
+			t.Fatalf("Expected %v but got %v", http.StatusFound, rec.Code)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is an OpenID provider which logs in everybody with the claims of the test
+type mockIssuer struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey *ecdsa.PrivateKey
+	lock sync.Mutex
+	claims map[string]interface{}
+	// logins are the code challenges and nonces of the issued codes
+	logins map[string][2]string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	m := &mockIssuer{rsaKey: rsaKey, ecKey: ecKey, logins: map[string][2]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer: m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint: m.server.URL + "/token",
+			JWKSURI: m.server.URL + "/jwks",
+			EndSessionEndpoint: m.server.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {
+			{Kid: "rsa", Kty: "RSA", Use: "sig", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kid: "ec", Kty: "EC", Crv: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())},
+		}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code, _ := randomToken()
+		m.lock.Lock()
+		m.logins[code] = [2]string{q.Get("code_challenge"), q.Get("nonce")}
+		m.lock.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri") + "?code=" + code + "&state=" + q.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.lock.Lock()
+		login, ok := m.logins[r.PostFormValue("code")]
+		delete(m.logins, r.PostFormValue("code"))
+		m.lock.Unlock()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != login[0] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]interface{}{"iss": m.server.URL, "aud": "docs", "exp": time.Now().Add(time.Hour).Unix(), "nonce": login[1]}
+		m.lock.Lock()
+		for name, value := range m.claims {
+			claims[name] = value
+		}
+		m.lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(t, "RS256", claims)})
+	})
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *mockIssuer) setClaims(claims map[string]interface{}) {
+	m.lock.Lock()
+	m.claims = claims
+	m.lock.Unlock()
+}
+
+func (m *mockIssuer) sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	kid := map[string]string{"RS256": "rsa", "ES256": "ec"}[alg]
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, m.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, m.ecKey, digest[:])
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.server.Close()
+	provider := newOIDCProvider(issuer.server.URL)
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"iss": issuer.server.URL, "aud": []string{"other", "docs"}, "exp": now.Add(time.Hour).Unix(), "nonce": "n", "sub": "alice"}
+	}
+	tests := []struct {
+		name string
+		token func() string
+		shouldContain string
+	}{
+		{name: "RS256", token: func() string { return issuer.sign(t, "RS256", valid()) }},
+		{name: "ES256", token: func() string { return issuer.sign(t, "ES256", valid()) }},
+		{name: "Wrong issuer", token: func() string {
+			claims := valid()
+			claims["iss"] = "https://evil.example.com"
+			return issuer.sign(t, "RS256", claims)
+		}, shouldContain: "id token issued by https://evil.example.com"},
+		{name: "Wrong audience", token: func() string {
+			claims := valid()
+			claims["aud"] = "other"
+			return issuer.sign(t, "RS256", claims)
+		}, shouldContain: "id token not issued for docs"},
+		{name: "Expired", token: func() string {
+			claims := valid()
+			claims["exp"] = now.Add(-2 * time.Minute).Unix()
+			return issuer.sign(t, "RS256", claims)
+		}, shouldContain: "id token expired"},
+		{name: "Wrong nonce", token: func() string {
+			claims := valid()
+			claims["nonce"] = "replayed"
+			return issuer.sign(t, "RS256", claims)
+		}, shouldContain: "wrong nonce"},
+		{name: "Tampered", token: func() string {
+			parts := strings.Split(issuer.sign(t, "RS256", valid()), ".")
+			claims := valid()
+			claims["sub"] = "mallory"
+			payload, _ := json.Marshal(claims)
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}, shouldContain: "invalid RS256 signature"},
+		{name: "Algorithm none", token: func() string {
+			header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "rsa"})
+			payload, _ := json.Marshal(valid())
+			return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+		}, shouldContain: "invalid none signature"},
+		{name: "Unknown key", token: func() string {
+			header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "rotated"})
+			return base64.RawURLEncoding.EncodeToString(header) + ".e30.c2ln"
+		}, shouldContain: "unknown key rotated"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := provider.verify(test.token(), "docs", "n", now)
+			if test.shouldContain == "" {
+				if err != nil {
+					t.Fatalf("expected no error got %v", err)
+				}
+				if claims["sub"] != "alice" {
+					t.Fatalf("Expected %v but got %v", "alice", claims["sub"])
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.shouldContain) {
+				t.Fatalf("%v should contain %v", err, test.shouldContain)
+			}
+		})
+	}
+}
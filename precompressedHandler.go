@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// encoding is a content coding with the extension of its precompressed files
+type encoding struct {
+	name string
+	ext string
+}
+
+// precompressedEncodings are ordered by preference, if the client accepts several of them
+var precompressedEncodings = []encoding{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// acceptsEncoding returns whether the Accept-Encoding header allows the coding
+func acceptsEncoding(acceptEncoding string, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if name == coding {
+			return accepted
+		}
+		if name == "*" {
+			wildcard = accepted
+		}
+	}
+	return wildcard
+}
+
+// requestedFile returns the name of the file a GET or HEAD request is served from.
+// It's false for other methods and for requests of index.html, which http.FileServer redirects to the directory.
+func requestedFile(r *http.Request) (string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || strings.HasSuffix(r.URL.Path, "/index.html") {
+		return "", false
+	}
+	name := r.URL.Path
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	return name, true
+}
+
+// openFile opens the name and returns its FileInfo, it fails for directories
+func openFile(fs http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, nil, os.ErrNotExist
+	}
+	return f, fi, nil
+}
+
+// addVary adds the header name to the Vary header, unless it's already contained
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// contentType determines the content type of a file by its extension or its content
+func contentType(name string, f io.ReadSeeker) string {
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype != "" {
+		return ctype
+	}
+	var buf [512]byte
+	n, _ := io.ReadFull(f, buf[:])
+	f.Seek(0, io.SeekStart)
+	return http.DetectContentType(buf[:n])
+}
+
+// HandlePrecompressed serves precompressed variants (app.js.br, app.js.zst, app.js.gz) of a file,
+// if they exist next to it and the client accepts their encoding.
+// Range requests and conditional requests apply to the variant which is served,
+// its ETag is computed from the content of the variant.
+func HandlePrecompressed(precompressed bool, etags *etagCache, fs http.FileSystem, h http.Handler) http.Handler {
+	if !precompressed {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := requestedFile(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, _, err := openFile(fs, name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		addVary(w.Header(), "Accept-Encoding")
+		acceptEncoding := r.Header.Get("Accept-Encoding")
+		for _, enc := range precompressedEncodings {
+			if !acceptsEncoding(acceptEncoding, enc.name) {
+				continue
+			}
+			variant, fi, err := openFile(fs, name + enc.ext)
+			if err != nil {
+				continue
+			}
+			defer variant.Close()
+			if w.Header().Get("ETag") == "" {
+				etag, err := etags.get(name + enc.ext, fi, variant)
+				if err != nil {
+					log.Printf("Could not hash %s: %v", name + enc.ext, err)
+					continue
+				}
+				if etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+			}
+			w.Header().Set("Content-Type", contentType(name, f))
+			w.Header().Set("Content-Encoding", enc.name)
+			http.ServeContent(w, r, name, fi.ModTime(), variant)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		coding string
+		accepted bool
+	}{
+		{acceptEncoding: "gzip, deflate, br", coding: "br", accepted: true},
+		{acceptEncoding: "gzip, deflate", coding: "br", accepted: false},
+		{acceptEncoding: "br;q=0, gzip", coding: "br", accepted: false},
+		{acceptEncoding: "BR;q=0.5", coding: "br", accepted: true},
+		{acceptEncoding: "*", coding: "zstd", accepted: true},
+		{acceptEncoding: "*, zstd;q=0", coding: "zstd", accepted: false},
+		{acceptEncoding: "", coding: "gzip", accepted: false},
+	}
+	for _, test := range tests {
+		if acceptsEncoding(test.acceptEncoding, test.coding) != test.accepted {
+			t.Fatalf("Expected %v to accept %v: %v", test.acceptEncoding, test.coding, test.accepted)
+		}
+	}
+}
+
+func TestPrecompressedHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/test.js.br", []byte("brotli js"))
+	writeFile(tempDir + "/test.js.gz", []byte("gzip js"))
+	writeFile(tempDir + "/test.txt.gz", []byte("gzip txt"))
+	writeFile(tempDir + "/orphan.css.gz", []byte("gzip css"))
+	writeFile(tempDir + "/index.html", []byte("<html>index</html>"))
+	writeFile(tempDir + "/index.html.gz", []byte("gzip index"))
+
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandlePrecompressed(true, newETagCache(defaultETagMaxSize), fs, http.FileServer(fs))
+
+	tests := []struct {
+		name string
+		URL string
+		acceptEncoding string
+		rangeHeader string
+		code int
+		body string
+		contentEncoding string
+		contentType string
+	}{
+		{name: "Brotli preferred", URL: "/test.js", acceptEncoding: "gzip, br", code: http.StatusOK, body: "brotli js", contentEncoding: "br", contentType: "javascript"},
+		{name: "Gzip", URL: "/test.js", acceptEncoding: "gzip", code: http.StatusOK, body: "gzip js", contentEncoding: "gzip", contentType: "javascript"},
+		{name: "Identity", URL: "/test.js", acceptEncoding: "", code: http.StatusOK, body: "/* some js */", contentType: "javascript"},
+		{name: "Missing variant", URL: "/test.txt", acceptEncoding: "br", code: http.StatusOK, body: "hello go", contentType: "text/plain"},
+		{name: "Variant without original", URL: "/orphan.css", acceptEncoding: "gzip", code: http.StatusNotFound, body: "404 page not found\n"},
+		{name: "Directory index", URL: "/", acceptEncoding: "gzip", code: http.StatusOK, body: "gzip index", contentEncoding: "gzip", contentType: "text/html"},
+		{name: "Range of variant", URL: "/test.js", acceptEncoding: "gzip", rangeHeader: "bytes=0-3", code: http.StatusPartialContent, body: "gzip", contentEncoding: "gzip", contentType: "javascript"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			if test.rangeHeader != "" {
+				r.Header.Set("Range", test.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			if rec.Body.String() != test.body {
+				t.Fatalf("Expected body %v but got %v", test.body, rec.Body.String())
+			}
+			if rec.Header().Get("Content-Encoding") != test.contentEncoding {
+				t.Fatalf("Expected Content-Encoding %v but got %v", test.contentEncoding, rec.Header().Get("Content-Encoding"))
+			}
+			if test.code == http.StatusNotFound {
+				return
+			}
+			if !strings.Contains(rec.Header().Get("Content-Type"), test.contentType) {
+				t.Fatalf("Expected Content-Type %v but got %v", test.contentType, rec.Header().Get("Content-Type"))
+			}
+			if rec.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("Expected Vary: Accept-Encoding but got %v", rec.Header().Get("Vary"))
+			}
+		})
+	}
+
+	t.Run("ETag differs per encoding", func(t *testing.T) {
+		etags := map[string]bool{}
+		for _, acceptEncoding := range []string{"br", "gzip"} {
+			r := httptest.NewRequest("GET", "/test.js", nil)
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			etag := rec.Header().Get("ETag")
+			if etag == "" || etags[etag] {
+				t.Fatalf("Expected a distinct ETag but got %v", etag)
+			}
+			etags[etag] = true
+
+			r = httptest.NewRequest("GET", "/test.js", nil)
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			r.Header.Set("If-None-Match", etag)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != http.StatusNotModified {
+				t.Fatalf("Expected %v but got %v", http.StatusNotModified, rec.Code)
+			}
+		}
+	})
+}
+
+func TestPrecompressedHandlerInMemory(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/test.js.zst", []byte("zstd js"))
+
+	memFS, err := newMemoryFilesystem(newDocroot(tempDir, SymlinksWithinRoot), false, false)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := justFilesFilesystem{fs: memFS}
+	h := HandlePrecompressed(true, newETagCache(defaultETagMaxSize), fs, http.FileServer(fs))
+
+	r := httptest.NewRequest("GET", "/test.js", nil)
+	r.Header.Set("Accept-Encoding", "zstd")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || rec.Body.String() != "zstd js" || rec.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("Expected zstd variant but got %v %v %v", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.String())
+	}
+}
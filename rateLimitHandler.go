@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitRule limits the requests of each client address to a path prefix with a token bucket
+type RateLimitRule struct {
+	// Path is the path prefix the rule applies to (default: /)
+	Path string `yaml:"path"`
+	// Rate is the number of requests per second a client may make on average
+	Rate float64 `yaml:"rate"`
+	// Burst is the number of requests a client may make at once (default: the rate rounded up)
+	Burst int `yaml:"burst"`
+}
+
+func (rule *RateLimitRule) finalize() error {
+	if rule.Path == "" {
+		rule.Path = "/"
+	}
+	if !strings.HasPrefix(rule.Path, "/") {
+		return fmt.Errorf("path %s must start with /", rule.Path)
+	}
+	if rule.Rate <= 0 {
+		return fmt.Errorf("rate of %s must be positive", rule.Path)
+	}
+	if rule.Burst == 0 {
+		rule.Burst = int(math.Ceil(rule.Rate))
+	}
+	if rule.Burst < 1 {
+		return fmt.Errorf("burst of %s must be positive", rule.Path)
+	}
+	return nil
+}
+
+// tokenBucket holds the tokens of a client at the time it last made a request
+type tokenBucket struct {
+	tokens float64
+	last time.Time
+}
+
+// tokenBuckets are the token buckets of all clients of a rule, full buckets are removed from time to time
+type tokenBuckets struct {
+	rate float64
+	burst float64
+	lock sync.Mutex
+	buckets map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucketSweepInterval is how often buckets which have been refilled completely are removed
+const tokenBucketSweepInterval = time.Minute
+
+func newTokenBuckets(rate float64, burst int) *tokenBuckets {
+	return &tokenBuckets{rate: rate, burst: float64(burst), buckets: map[string]*tokenBucket{}}
+}
+
+func (b *tokenBuckets) refill(bucket *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(b.burst, bucket.tokens + elapsed * b.rate)
+	}
+	bucket.last = now
+}
+
+// sweep removes the buckets which have been refilled completely, the lock must be held
+func (b *tokenBuckets) sweep(now time.Time) {
+	for key, bucket := range b.buckets {
+		if b.refill(bucket, now); bucket.tokens >= b.burst {
+			delete(b.buckets, key)
+		}
+	}
+	b.lastSweep = now
+}
+
+// take takes a token of the client, if none is left it returns how long the client has to wait for the next one
+func (b *tokenBuckets) take(client string, now time.Time) (bool, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if now.Sub(b.lastSweep) > tokenBucketSweepInterval {
+		b.sweep(now)
+	}
+	bucket, ok := b.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: b.burst, last: now}
+		b.buckets[client] = bucket
+	}
+	b.refill(bucket, now)
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / b.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// rateLimitStore keeps the token buckets of the rate limit rules across reloads,
+// so that reloading doesn't give every client a full burst again
+type rateLimitStore struct {
+	lock sync.Mutex
+	buckets map[string]*tokenBuckets
+}
+
+func newRateLimitStore() *rateLimitStore {
+	return &rateLimitStore{buckets: map[string]*tokenBuckets{}}
+}
+
+// get returns the buckets of the rule of the site, they're shared with the previous handlers of an unchanged rule
+func (s *rateLimitStore) get(site string, rule RateLimitRule) *tokenBuckets {
+	key := fmt.Sprintf("%s %s %g %d", site, rule.Path, rule.Rate, rule.Burst)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	buckets, ok := s.buckets[key]
+	if !ok {
+		buckets = newTokenBuckets(rule.Rate, rule.Burst)
+		s.buckets[key] = buckets
+	}
+	return buckets
+}
+
+// prune removes the rules whose buckets have all been refilled, which drops removed rules without losing any state
+func (s *rateLimitStore) prune(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, buckets := range s.buckets {
+		buckets.lock.Lock()
+		buckets.sweep(now)
+		empty := len(buckets.buckets) == 0
+		buckets.lock.Unlock()
+		if empty {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// HandleRateLimits answers 429 with a Retry-After header if a client exceeds the rate of the longest rule matching the path.
+// Clients are distinguished by their address, which is resolved by HandleClientIP behind trusted proxies.
+// The buckets of the site's rules are taken from the store.
+func HandleRateLimits(rules []RateLimitRule, store *rateLimitStore, site string, h http.Handler) http.Handler {
+	if len(rules) == 0 {
+		return h
+	}
+	buckets := make([]*tokenBuckets, len(rules))
+	for i, rule := range rules {
+		buckets[i] = store.get(site, rule)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleanPath := path.Clean("/" + r.URL.Path)
+		rule := -1
+		for i := range rules {
+			if belowPath(rules[i].Path, cleanPath) && (rule < 0 || len(rules[i].Path) > len(rules[rule].Path)) {
+				rule = i
+			}
+		}
+		if rule < 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		client := r.RemoteAddr
+		if ip := clientIP(r); ip != nil {
+			client = ip.String()
+		}
+		if ok, wait := buckets[rule].take(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBuckets(t *testing.T) {
+	buckets := newTokenBuckets(2, 3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := buckets.take("a", now); !ok {
+			t.Fatalf("Expected request %v of the burst to be allowed", i)
+		}
+	}
+	ok, wait := buckets.take("a", now)
+	if ok || wait != 500 * time.Millisecond {
+		t.Fatalf("Expected to wait %v but got %v %v", 500 * time.Millisecond, ok, wait)
+	}
+	if ok, _ := buckets.take("b", now); !ok {
+		t.Fatalf("Expected other clients to have their own bucket")
+	}
+	if ok, _ := buckets.take("a", now.Add(500 * time.Millisecond)); !ok {
+		t.Fatalf("Expected the bucket to be refilled")
+	}
+	if ok, _ := buckets.take("a", now.Add(500 * time.Millisecond)); ok {
+		t.Fatalf("Expected the bucket to be empty")
+	}
+	buckets.take("c", now.Add(2 * tokenBucketSweepInterval))
+	if len(buckets.buckets) != 1 {
+		t.Fatalf("Expected full buckets to be removed but got %v", buckets.buckets)
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	rules := []RateLimitRule{
+		{Rate: 100},
+		{Path: "/mirror", Rate: 0.5, Burst: 1},
+	}
+	for i := range rules {
+		if err := rules[i].finalize(); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	trusted, _ := parseIPList([]string{"10.0.0.0/8"})
+	h := HandleClientIP(trusted, "X-Forwarded-For", HandleRateLimits(rules, newRateLimitStore(), "on port 8100", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	})))
+	get := func(url string, remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", url, nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := get("/mirror/big.iso", "192.0.2.1:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+	}
+	rec := get("/mirror/big.iso", "192.0.2.1:1235", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected %v but got %v", http.StatusTooManyRequests, rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "2" {
+		t.Fatalf("Expected Retry-After %v but got %v", "2", retryAfter)
+	}
+	if rec := get("/index.html", "192.0.2.1:1235", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected other paths to be limited by the site rule but got %v", rec.Code)
+	}
+	if rec := get("/mirror/big.iso", "192.0.2.2:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected other clients not to be limited but got %v", rec.Code)
+	}
+	if rec := get("/mirror/big.iso", "10.0.0.1:1234", "198.51.100.1"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first request of a client behind a proxy to be allowed but got %v", rec.Code)
+	}
+	if rec := get("/mirror/big.iso", "10.0.0.1:1234", "198.51.100.2"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected clients behind the same proxy to be limited separately but got %v", rec.Code)
+	}
+	if rec := get("/mirror/big.iso", "10.0.0.2:1234", "198.51.100.1"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected %v but got %v", http.StatusTooManyRequests, rec.Code)
+	}
+}
+
+func TestRateLimitsSurviveReload(t *testing.T) {
+	store := newRateLimitStore()
+	build := func(rules []RateLimitRule) http.Handler {
+		for i := range rules {
+			if err := rules[i].finalize(); err != nil {
+				t.Fatalf("expected no error got %v", err)
+			}
+		}
+		store.prune(time.Now())
+		return HandleRateLimits(rules, store, "on port 8100", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("content"))
+		}))
+	}
+	get := func(h http.Handler) int {
+		r := httptest.NewRequest("GET", "/mirror/big.iso", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	h := build([]RateLimitRule{{Path: "/mirror", Rate: 0.1, Burst: 1}})
+	if code := get(h); code != http.StatusOK {
+		t.Fatalf("Expected %v but got %v", http.StatusOK, code)
+	}
+	h = build([]RateLimitRule{{Path: "/mirror", Rate: 0.1, Burst: 1}})
+	if code := get(h); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the bucket to be kept across reloads but got %v", code)
+	}
+	h = build([]RateLimitRule{{Path: "/mirror", Rate: 0.1, Burst: 2}})
+	if code := get(h); code != http.StatusOK {
+		t.Fatalf("Expected a changed rule to get new buckets but got %v", code)
+	}
+	if len(store.buckets) != 2 {
+		t.Fatalf("Expected the buckets of the removed rule to be kept until they're refilled but got %v", store.buckets)
+	}
+	store.prune(time.Now().Add(time.Minute))
+	if len(store.buckets) != 0 {
+		t.Fatalf("Expected refilled buckets to be pruned but got %v", store.buckets)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadableFile keeps the parsed content of a file until its modification time or size changes
+type reloadableFile struct {
+	name string
+	// open opens the file by its name, from the disk or from the filesystem of a docroot
+	open func(name string) (http.File, error)
+	parse func(r io.Reader) (interface{}, error)
+	lock sync.RWMutex
+	modTime time.Time
+	size int64
+	content interface{}
+	loaded bool
+}
+
+// openOSFile opens a file on the disk, e.g. one configured outside of the docroot
+func openOSFile(name string) (http.File, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// load returns the content of the file, it is parsed again if the file changed since it was last read
+func (f *reloadableFile) load() (interface{}, error) {
+	file, err := f.open(f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", f.name)
+	}
+	f.lock.RLock()
+	content, unchanged := f.content, f.loaded && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size
+	f.lock.RUnlock()
+	if unchanged {
+		return content, nil
+	}
+	content, err = f.parse(file)
+	if err != nil {
+		return nil, err
+	}
+	f.lock.Lock()
+	f.content, f.modTime, f.size, f.loaded = content, fi.ModTime(), fi.Size(), true
+	f.lock.Unlock()
+	return content, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReloadableFile(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/list.txt", []byte("first"))
+	parsed := 0
+	f := &reloadableFile{name: tempDir + "/list.txt", open: openOSFile, parse: func(r io.Reader) (interface{}, error) {
+		parsed++
+		content, err := ioutil.ReadAll(r)
+		if string(content) == "invalid" {
+			return nil, errors.New("invalid content")
+		}
+		return string(content), err
+	}}
+	load := func(expected string) {
+		content, err := f.load()
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		if content != expected {
+			t.Fatalf("Expected %v but got %v", expected, content)
+		}
+	}
+	touch := func(content string, modTime time.Time) {
+		writeFile(tempDir + "/list.txt", []byte(content))
+		if err := os.Chtimes(tempDir + "/list.txt", modTime, modTime); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+
+	t.Run("Parsed once while unchanged", func(t *testing.T) {
+		load("first")
+		load("first")
+		if parsed != 1 {
+			t.Fatalf("Expected %v but got %v", 1, parsed)
+		}
+	})
+
+	t.Run("Reloaded on change", func(t *testing.T) {
+		touch("second", time.Now().Add(time.Minute))
+		load("second")
+		if parsed != 2 {
+			t.Fatalf("Expected %v but got %v", 2, parsed)
+		}
+	})
+
+	t.Run("Invalid content is an error", func(t *testing.T) {
+		touch("invalid", time.Now().Add(2 * time.Minute))
+		if _, err := f.load(); err == nil {
+			t.Fatalf("Expected an error")
+		}
+	})
+
+	t.Run("Missing file is an error", func(t *testing.T) {
+		os.Remove(tempDir + "/list.txt")
+		if _, err := f.load(); !os.IsNotExist(err) {
+			t.Fatalf("Expected a not exist error but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeadersHandler(t *testing.T) {
+	config := &SecurityHeadersConfig{
+		Preset: "strict",
+		Headers: map[string]string{
+			"content-security-policy": "default-src 'self' cdn.example.com",
+			"Cross-Origin-Opener-Policy": "",
+			"X-Custom": "yes",
+		},
+	}
+	if err := config.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	h := HandleSecurityHeaders(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/framed" {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name string
+		URL string
+		tls bool
+		expected map[string]string
+	}{
+		{name: "Preset", URL: "/", expected: map[string]string{"X-Content-Type-Options": "nosniff", "X-Frame-Options": "DENY", "Referrer-Policy": "no-referrer"}},
+		{name: "Overrides", URL: "/", expected: map[string]string{"Content-Security-Policy": "default-src 'self' cdn.example.com", "Cross-Origin-Opener-Policy": "", "X-Custom": "yes"}},
+		{name: "No HSTS without TLS", URL: "/", expected: map[string]string{"Strict-Transport-Security": ""}},
+		{name: "HSTS with TLS", URL: "/", tls: true, expected: map[string]string{"Strict-Transport-Security": "max-age=63072000; includeSubDomains"}},
+		{name: "Inner handler takes precedence", URL: "/framed", expected: map[string]string{"X-Frame-Options": "SAMEORIGIN"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			if test.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			for name, value := range test.expected {
+				if rec.Header().Get(name) != value {
+					t.Fatalf("Expected %s %v but got %v", name, value, rec.Header().Get(name))
+				}
+			}
+		})
+	}
+}
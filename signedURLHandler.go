@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignedURLConfig requires links below the path prefixes to carry an expiry and a signature
+type SignedURLConfig struct {
+	// Paths are the protected path prefixes
+	Paths []string `yaml:"paths"`
+	// Secrets are the shared secrets, the first signs links and all of them are accepted, so secrets can be rotated
+	Secrets []string `yaml:"secrets"`
+}
+
+func (c *SignedURLConfig) finalize() error {
+	if len(c.Paths) == 0 {
+		return fmt.Errorf("no paths configured")
+	}
+	for _, p := range c.Paths {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("path %s must start with /", p)
+		}
+	}
+	if len(c.Secrets) == 0 {
+		return fmt.Errorf("no secrets configured")
+	}
+	for _, secret := range c.Secrets {
+		if len(secret) < 16 {
+			return fmt.Errorf("secrets must be at least 16 characters long")
+		}
+	}
+	return nil
+}
+
+// protects returns whether the cleaned path is below one of the protected path prefixes
+func (c *SignedURLConfig) protects(cleanPath string) bool {
+	for _, p := range c.Paths {
+		if belowPath(p, cleanPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlSignature is the HMAC-SHA256 of the cleaned path and the expiry
+func urlSignature(secret string, cleanPath string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(cleanPath + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signURL returns the query which allows accessing the path until it expires
+func signURL(secret string, urlPath string, expires time.Time) string {
+	return url.Values{
+		"expires": {strconv.FormatInt(expires.Unix(), 10)},
+		"sig": {urlSignature(secret, path.Clean("/" + urlPath), expires.Unix())},
+	}.Encode()
+}
+
+var (
+	errURLExpired = errors.New("link expired")
+	errURLSignature = errors.New("invalid signature")
+)
+
+// verify checks the expiry and signature of the request, signatures of all secrets are accepted
+func (c *SignedURLConfig) verify(cleanPath string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return errURLSignature
+	}
+	if now.Unix() > expires {
+		return errURLExpired
+	}
+	sig := []byte(query.Get("sig"))
+	for _, secret := range c.Secrets {
+		if hmac.Equal(sig, []byte(urlSignature(secret, cleanPath, expires))) {
+			return nil
+		}
+	}
+	return errURLSignature
+}
+
+// HandleSignedURLs answers 403 for requests below the protected path prefixes without a valid signature or with an expired one
+func HandleSignedURLs(config *SignedURLConfig, h http.Handler) http.Handler {
+	if config == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cleanPath := path.Clean("/" + r.URL.Path)
+		if config.protects(cleanPath) {
+			if err := config.verify(cleanPath, r.URL.Query(), time.Now()); err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden) + ": " + err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// signCommand implements static-serve sign, which prints a signed link to a path
+func signCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	secretFlag := flags.String("secret", "", "the secret to sign with (default: $STATIC_SERVE_SIGNING_SECRET)")
+	configFlag := flags.String("config", "", "sign with the first secret of the site in this config file whose signedURLs protect the path")
+	hostFlag := flags.String("host", "", "with -config, only consider the sites of this host")
+	expiresFlag := flags.Duration("expires", 24 * time.Hour, "how long the link is valid")
+	baseFlag := flags.String("base", "", "the URL the path is appended to, e.g. https://downloads.example.com")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s sign [flags] <path>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	urlPath := path.Clean("/" + flags.Arg(0))
+	secret := *secretFlag
+	if *configFlag != "" {
+		cfg, err := loadConfig(*configFlag)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not load config %s: %v\n", *configFlag, err)
+			return 1
+		}
+		for _, site := range cfg.Sites {
+			if site.SignedURLs != nil && site.SignedURLs.protects(urlPath) && (*hostFlag == "" || containsString(site.Hosts, normalizeHost(*hostFlag))) {
+				secret = site.SignedURLs.Secrets[0]
+				break
+			}
+		}
+		if secret == "" {
+			fmt.Fprintf(stderr, "No site in %s signs %s\n", *configFlag, urlPath)
+			return 1
+		}
+	}
+	if secret == "" {
+		secret = os.Getenv("STATIC_SERVE_SIGNING_SECRET")
+	}
+	if secret == "" {
+		fmt.Fprintf(stderr, "Either -secret, -config or $STATIC_SERVE_SIGNING_SECRET is required\n")
+		return 2
+	}
+	link := (&url.URL{Path: urlPath}).EscapedPath() + "?" + signURL(secret, urlPath, time.Now().Add(*expiresFlag))
+	fmt.Fprintln(stdout, strings.TrimSuffix(*baseFlag, "/") + link)
+	return 0
+}
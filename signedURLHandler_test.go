@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLHandler(t *testing.T) {
+	const (
+		current = "current-secret-0123456789"
+		rotated = "rotated-secret-0123456789"
+	)
+	config := &SignedURLConfig{Paths: []string{"/reports"}, Secrets: []string{current, rotated}}
+	if err := config.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	h := HandleSignedURLs(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	future := time.Now().Add(time.Hour)
+	tampered := signURL(current, "/reports/acme.pdf", future)
+	tampered = strings.Replace(tampered, "expires=" + strconv.FormatInt(future.Unix(), 10), "expires=" + strconv.FormatInt(future.Unix() + 3600, 10), 1)
+
+	tests := []struct {
+		name string
+		URL string
+		code int
+	}{
+		{name: "Unprotected path", URL: "/public/index.html", code: http.StatusOK},
+		{name: "Prefix is matched by path segments", URL: "/reports-archive/2019.pdf", code: http.StatusOK},
+		{name: "Unsigned", URL: "/reports/acme.pdf", code: http.StatusForbidden},
+		{name: "Signed", URL: "/reports/acme.pdf?" + signURL(current, "/reports/acme.pdf", future), code: http.StatusOK},
+		{name: "Signed with rotated secret", URL: "/reports/acme.pdf?" + signURL(rotated, "/reports/acme.pdf", future), code: http.StatusOK},
+		{name: "Signed with unknown secret", URL: "/reports/acme.pdf?" + signURL("unknown-secret-0123456789", "/reports/acme.pdf", future), code: http.StatusForbidden},
+		{name: "Signed for other path", URL: "/reports/other.pdf?" + signURL(current, "/reports/acme.pdf", future), code: http.StatusForbidden},
+		{name: "Unclean path", URL: "/public/../reports/acme.pdf?" + signURL(current, "/reports/acme.pdf", future), code: http.StatusOK},
+		{name: "Expired", URL: "/reports/acme.pdf?" + signURL(current, "/reports/acme.pdf", time.Now().Add(-time.Second)), code: http.StatusForbidden},
+		{name: "Tampered expiry", URL: "/reports/acme.pdf?" + tampered, code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, err := url.Parse(test.URL)
+			if err != nil {
+				t.Fatalf("expected no error got %v", err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.URL = u
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestSignCommand(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/config.yaml", []byte(`sites:
+  - port: "8100"
+    directory: ` + tempDir + `
+    signedURLs:
+      paths: [/reports]
+      secrets: [current-secret-0123456789, rotated-secret-0123456789]
+`))
+	config := &SignedURLConfig{Paths: []string{"/reports"}, Secrets: []string{"rotated-secret-0123456789", "current-secret-0123456789"}}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+		prefix string
+	}{
+		{name: "Secret", args: []string{"-secret", "current-secret-0123456789", "-base", "https://downloads.example.com/", "/reports/a b.pdf"}, prefix: "https://downloads.example.com/reports/a%20b.pdf?expires="},
+		{name: "Config", args: []string{"-config", tempDir + "/config.yaml", "-expires", "1h", "reports/a b.pdf"}, prefix: "/reports/a%20b.pdf?expires="},
+		{name: "Unprotected path", args: []string{"-config", tempDir + "/config.yaml", "/public/a.pdf"}, code: 1},
+		{name: "No path", args: []string{"-secret", "current-secret-0123456789"}, code: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			code := signCommand(test.args, stdout, stderr)
+			if code != test.code {
+				t.Fatalf("Expected %v but got %v: %v", test.code, code, stderr.String())
+			}
+			if code != 0 {
+				return
+			}
+			link := strings.TrimSpace(stdout.String())
+			if !strings.HasPrefix(link, test.prefix) {
+				t.Fatalf("%v should start with %v", link, test.prefix)
+			}
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatalf("expected no error got %v", err)
+			}
+			if err := config.verify(u.Path, u.Query(), time.Now()); err != nil {
+				t.Fatalf("expected no error got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"github.com/felixge/httpsnoop"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SPAConfig configures the fallback to the index of a single page application
+type SPAConfig struct {
+	// Index is the file served for navigation requests which don't match a file (default: /index.html)
+	Index string `yaml:"index"`
+	// Include restricts the fallback to paths matching these globs
+	Include []string `yaml:"include"`
+	// Exclude disables the fallback for paths matching these globs (e.g. /api/**)
+	Exclude []string `yaml:"exclude"`
+	include globList
+	exclude globList
+}
+
+func (c *SPAConfig) finalize() error {
+	if c.Index == "" {
+		c.Index = "/index.html"
+	}
+	if !strings.HasPrefix(c.Index, "/") {
+		c.Index = "/" + c.Index
+	}
+	var err error
+	if c.include, err = compileGlobs(c.Include); err != nil {
+		return err
+	}
+	c.exclude, err = compileGlobs(c.Exclude)
+	return err
+}
+
+// isNavigation returns whether the request was made by a browser navigating to a page of the application,
+// as opposed to a request for an asset like a script, stylesheet or image.
+func (c *SPAConfig) isNavigation(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if len(c.include) > 0 && !c.include.match(r.URL.Path) {
+		return false
+	}
+	if c.exclude.match(r.URL.Path) {
+		return false
+	}
+	if path.Ext(r.URL.Path) != "" {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// HandleSPA serves the index of a single page application for navigation requests which result in a 404,
+// so that the application can handle the route on the client side.
+// Other requests (e.g. for missing assets) still result in a 404.
+func HandleSPA(spa *SPAConfig, fs http.FileSystem, shouldLog bool, h http.Handler) http.Handler {
+	if spa == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !spa.isNavigation(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var (
+			isError404 = false
+			hooks = httpsnoop.Hooks{
+				WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(code int) {
+						if code == http.StatusNotFound {
+							isError404 = true
+						} else {
+							next(code)
+						}
+					}
+				},
+				Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(p []byte) (int, error) {
+						if !isError404 {
+							return next(p)
+						}
+						return 0, ignoreError404
+					}
+				},
+			}
+			originalHeader = http.Header{}
+		)
+		CopyHeaders(originalHeader, w.Header())
+		h.ServeHTTP(httpsnoop.Wrap(w, hooks), r)
+		if !isError404 {
+			return
+		}
+		SetHeaders(w.Header(), originalHeader)
+		f, err := fs.Open(spa.Index)
+		if err != nil {
+			log.Printf("Could not open SPA index %s: %v", spa.Index, err)
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		if shouldLog {
+			log.Printf("Did not find %s, serving %s instead", r.URL.Path, spa.Index)
+		}
+		http.ServeContent(w, r, spa.Index, fi.ModTime(), f)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSPAHandler(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/index.html", []byte("<html><body>App</body></html>"))
+	err := os.Mkdir(tempDir + "/assets", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/assets/app.js", []byte("/* app */"))
+
+	spa := &SPAConfig{Exclude: []string{"/api/**"}}
+	if err := spa.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandleSPA(spa, fs, false, http.FileServer(fs))
+
+	tests := []struct {
+		name string
+		method string
+		URL string
+		accept string
+		code int
+		shouldContain string
+	}{
+		{name: "Existing asset", method: "GET", URL: "/assets/app.js", accept: "*/*", code: http.StatusOK, shouldContain: "/* app */"},
+		{name: "Client side route", method: "GET", URL: "/users/42", accept: "text/html,application/xhtml+xml", code: http.StatusOK, shouldContain: "App"},
+		{name: "Root", method: "GET", URL: "/", accept: "text/html", code: http.StatusOK, shouldContain: "App"},
+		{name: "Client side route with HEAD", method: "HEAD", URL: "/users/42", accept: "text/html", code: http.StatusOK},
+		{name: "Missing script", method: "GET", URL: "/assets/missing.js", accept: "text/html", code: http.StatusNotFound, shouldContain: "404 page not found"},
+		{name: "Missing route without html accept", method: "GET", URL: "/users/42", accept: "application/json", code: http.StatusNotFound, shouldContain: "404 page not found"},
+		{name: "Excluded route", method: "GET", URL: "/api/users", accept: "text/html", code: http.StatusNotFound, shouldContain: "404 page not found"},
+		{name: "POST is no navigation", method: "POST", URL: "/users/42", accept: "text/html", code: http.StatusNotFound, shouldContain: "404 page not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.URL, nil)
+			r.Header.Set("Accept", test.accept)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, test.shouldContain) {
+				t.Fatalf("%v should contain %v", body, test.shouldContain)
+			}
+		})
+	}
+}
+
+func TestSPAHandlerInclude(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	writeFile(tempDir + "/app.html", []byte("<html><body>App</body></html>"))
+
+	spa := &SPAConfig{Index: "app.html", Include: []string{"/app/**"}}
+	if err := spa.finalize(); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := justFilesFilesystem{fs: http.Dir(tempDir)}
+	h := HandleSPA(spa, fs, false, http.FileServer(fs))
+
+	for url, code := range map[string]int{"/app/settings": http.StatusOK, "/other/settings": http.StatusNotFound} {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != code {
+			t.Fatalf("%v: Expected %v but got %v", url, code, rec.Code)
+		}
+	}
+}
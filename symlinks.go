@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type SymlinkPolicy string
+const (
+	SymlinksDeny SymlinkPolicy = "deny"
+	SymlinksWithinRoot = "within-root"
+	SymlinksFollow = "follow"
+)
+var symlinkPolicies = []SymlinkPolicy{SymlinksDeny, SymlinksWithinRoot, SymlinksFollow}
+var invalidSymlinkPolicy = errors.New("Invalid symlink policy")
+func (i *SymlinkPolicy) String() string {
+	return string(*i)
+}
+func (i *SymlinkPolicy) Set(value string) error {
+	input := SymlinkPolicy(strings.ToLower(value))
+	for _, val := range symlinkPolicies {
+		if val == input {
+			*i = val
+			return nil
+		}
+	}
+	return invalidSymlinkPolicy
+}
+
+// docroot is a directory on disk whose symlinks are resolved according to a policy
+type docroot struct {
+	root string
+	symlinks SymlinkPolicy
+}
+
+func newDocroot(root string, symlinks SymlinkPolicy) *docroot {
+	return &docroot{root: root, symlinks: symlinks}
+}
+
+// path returns the path on disk of a slash separated name
+func (d *docroot) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(path.Clean("/" + name)))
+}
+
+// allowed returns whether the name may be served, that is whether it contains no symlinks
+// or its symlinks are allowed by the policy. Denied symlinks are logged.
+func (d *docroot) allowed(name string) bool {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return true
+	}
+	linked := false
+	for i := 1; i <= len(name); i++ {
+		if i < len(name) && name[i] != '/' {
+			continue
+		}
+		fi, err := os.Lstat(d.path(name[:i]))
+		if err != nil {
+			// missing files are reported when they are opened
+			return true
+		}
+		if fi.Mode() & os.ModeSymlink != 0 {
+			linked = true
+			break
+		}
+	}
+	if !linked || d.symlinks == SymlinksFollow {
+		return true
+	}
+	if d.symlinks == SymlinksDeny {
+		log.Printf("Denied access to %s: symlinks are not allowed", name)
+		return false
+	}
+	// the root itself may be a symlink which is swapped on deploys, so it's resolved every time
+	realRoot, err := filepath.EvalSymlinks(d.root)
+	if err != nil {
+		return false
+	}
+	target, err := filepath.EvalSymlinks(d.path(name))
+	if err != nil {
+		return false
+	}
+	if rel, err := filepath.Rel(realRoot, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+		log.Printf("Denied access to %s: symlink escapes the docroot to %s", name, target)
+		return false
+	}
+	return true
+}
+
+// diskFilesystem serves files from disk like http.Dir, applying the symlink policy
+type diskFilesystem struct {
+	docroot *docroot
+}
+
+func (fs diskFilesystem) Open(name string) (http.File, error) {
+	if !fs.docroot.allowed(name) {
+		return nil, os.ErrNotExist
+	}
+	return http.Dir(fs.docroot.root).Open(name)
+}
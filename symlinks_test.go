@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func setupSymlinks(t *testing.T) (tempDir string, outside string) {
+	tempDir = setupFS()
+	outside = setupFS()
+	writeFile(outside + "/secret.txt", []byte("secret"))
+	err := os.Mkdir(tempDir + "/real", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/real/inside.txt", []byte("inside"))
+	for link, target := range map[string]string{
+		"/inside-link.txt": tempDir + "/real/inside.txt",
+		"/inside-dir": tempDir + "/real",
+		"/outside-link.txt": outside + "/secret.txt",
+		"/outside-dir": outside,
+		"/loop": tempDir,
+	} {
+		if err := os.Symlink(target, tempDir + link); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+	return tempDir, outside
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	tempDir, outside := setupSymlinks(t)
+	defer cleanTempDir(tempDir)
+	defer cleanTempDir(outside)
+
+	files := []string{"/real/inside.txt", "/inside-link.txt", "/inside-dir/inside.txt", "/outside-link.txt", "/outside-dir/secret.txt"}
+	expected := map[SymlinkPolicy][]bool{
+		SymlinksDeny: {true, false, false, false, false},
+		SymlinksWithinRoot: {true, true, true, false, false},
+		SymlinksFollow: {true, true, true, true, true},
+	}
+	for _, policy := range symlinkPolicies {
+		for _, fsType := range fsTypes {
+			t.Run(string(policy) + " " + string(fsType), func(t *testing.T) {
+				root := newDocroot(tempDir, policy)
+				var fs http.FileSystem = diskFilesystem{root}
+				if fsType != DiskFS {
+					memoryFS, err := newMemoryFilesystem(root, fsType == INMem, false)
+					if err != nil {
+						t.Fatalf("expected no error got %v", err)
+					}
+					defer memoryFS.Close()
+					fs = memoryFS
+				}
+				for i, name := range files {
+					f, err := fs.Open(name)
+					if (err == nil) != expected[policy][i] {
+						t.Fatalf("Expected %s to be served: %v but got %v", name, expected[policy][i], err)
+					}
+					if err != nil {
+						if !os.IsNotExist(err) {
+							t.Fatalf("Expected a not exist error but got %v", err)
+						}
+						continue
+					}
+					content, _ := ioutil.ReadAll(f)
+					f.Close()
+					if len(content) == 0 {
+						t.Fatalf("Expected content for %s", name)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSymlinkedDocroot(t *testing.T) {
+	tempDir, outside := setupSymlinks(t)
+	defer cleanTempDir(tempDir)
+	defer cleanTempDir(outside)
+	link := outside + "/current"
+	if err := os.Symlink(tempDir, link); err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	fs := diskFilesystem{newDocroot(link, SymlinksWithinRoot)}
+	for _, name := range []string{"/test.txt", "/inside-link.txt"} {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+		f.Close()
+	}
+	if _, err := fs.Open("/outside-link.txt"); err == nil {
+		t.Fatalf("Expected the symlink out of the docroot to be denied")
+	}
+}
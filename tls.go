@@ -2,22 +2,22 @@ package main
 
 import (
 	"crypto/tls"
-	"log"
+	"errors"
 )
 
-func loadTlsConfig(verbose bool, tlsCertFlag string, tlsKeyFlag string) *tls.Config {
+func loadCertificate(tlsCertFlag string, tlsKeyFlag string) (*tls.Certificate, error) {
 	if tlsCertFlag == "" && tlsKeyFlag == "" {
-		return nil
+		return nil, nil
 	}
 	if tlsCertFlag == "" {
-		log.Fatal("Path to TLS key file ist set. Path to certificate file is required, but missing.")
+		return nil, errors.New("Path to TLS key file ist set. Path to certificate file is required, but missing.")
 	}
 	if tlsKeyFlag == "" {
-		log.Fatal("Path to TLS certificate file ist set. Path to certificate key file is required, but missing.")
+		return nil, errors.New("Path to TLS certificate file ist set. Path to certificate key file is required, but missing.")
 	}
 	cert, err := tls.LoadX509KeyPair(tlsCertFlag, tlsKeyFlag)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return &tls.Config{Certificates: []tls.Certificate{cert}}
+	return &cert, nil
 }
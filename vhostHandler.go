@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HandleVirtualHosts dispatches requests by their Host header.
+// Exact host names take precedence over patterns, which are tried in order.
+// Requests for unknown hosts are passed to the fallback handler.
+func HandleVirtualHosts(hosts map[string]http.Handler, patterns []*hostPattern, fallback http.Handler) http.Handler {
+	if len(hosts) == 0 && len(patterns) == 0 {
+		return fallback
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := normalizeHost(r.Host)
+		if h, ok := hosts[host]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, pattern := range patterns {
+			if captures, ok := pattern.match(host); ok {
+				if h := pattern.handler(captures); h != nil {
+					h.ServeHTTP(w, r)
+					return
+				}
+				break
+			}
+		}
+		fallback.ServeHTTP(w, r)
+	})
+}
+
+// normalizeHost strips the port and the trailing dot of a host and lower-cases it
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
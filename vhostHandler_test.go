@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	docsDir := setupFS()
+	defer cleanTempDir(docsDir)
+	appDir := setupFS()
+	defer cleanTempDir(appDir)
+	writeFile(docsDir + "/docs.txt", []byte("docs"))
+	writeFile(appDir + "/app.txt", []byte("app"))
+
+	docs := http.FileServer(justFilesFilesystem{fs: http.Dir(docsDir)})
+	app := http.FileServer(justFilesFilesystem{fs: http.Dir(appDir)})
+	h := HandleVirtualHosts(map[string]http.Handler{"docs.example.com": docs, "app.example.com": app}, nil, http.NotFoundHandler())
+
+	tests := []struct {
+		name string
+		host string
+		URL string
+		code int
+		shouldContain string
+	}{
+		{name: "Docs host", host: "docs.example.com", URL: "/docs.txt", code: http.StatusOK, shouldContain: "docs"},
+		{name: "App host", host: "app.example.com", URL: "/app.txt", code: http.StatusOK, shouldContain: "app"},
+		{name: "Host with port and upper case", host: "App.Example.com:8100", URL: "/app.txt", code: http.StatusOK, shouldContain: "app"},
+		{name: "Host with trailing dot", host: "docs.example.com.", URL: "/docs.txt", code: http.StatusOK, shouldContain: "docs"},
+		{name: "Files of other hosts are not served", host: "docs.example.com", URL: "/app.txt", code: http.StatusNotFound, shouldContain: "404 page not found"},
+		{name: "Unknown host uses fallback", host: "other.example.com", URL: "/docs.txt", code: http.StatusNotFound, shouldContain: "404 page not found"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.URL, nil)
+			r.Host = test.host
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != test.code {
+				t.Fatalf("Expected %v but got %v", test.code, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, test.shouldContain) {
+				t.Fatalf("%v should contain %v", body, test.shouldContain)
+			}
+		})
+	}
+}
+
+func TestVirtualHostsWithoutHosts(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+
+	h := HandleVirtualHosts(nil, nil, http.FileServer(justFilesFilesystem{fs: http.Dir(tempDir)}))
+
+	tests := []loggerTest{
+		{
+			name: "Fallback serves all hosts",
+			method: "GET",
+			URL: "http://any.example.com/test.txt",
+			tests: func (t *testing.T, logs *bytes.Buffer, rec *httptest.ResponseRecorder) {
+				if rec.Code != http.StatusOK {
+					t.Fatalf("Expected %v but got %v", http.StatusOK, rec.Code)
+				}
+				shouldContain := "hello go"
+				body := string(rec.Body.Bytes())
+				if !strings.Contains(body, shouldContain) {
+					t.Fatalf("%v should contain %v", body, shouldContain)
+				}
+			},
+		},
+	}
+
+	runLoggerTests(t, h, tests)
+}
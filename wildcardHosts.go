@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var directoryPlaceholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// hostPattern matches host names like *.preview.example.com, each * matches exactly one label
+type hostPattern struct {
+	labels []string
+	// handler returns the handler for the labels matched by the wildcards, nil if there is none
+	handler func(captures []string) http.Handler
+}
+
+func isHostPattern(host string) bool {
+	return strings.Contains(host, "*")
+}
+
+func parseHostPattern(pattern string) (*hostPattern, error) {
+	labels := strings.Split(pattern, ".")
+	for _, label := range labels {
+		if label != "*" && strings.Contains(label, "*") {
+			return nil, fmt.Errorf("invalid host pattern %s: * must match a whole label", pattern)
+		}
+	}
+	return &hostPattern{labels: labels}, nil
+}
+
+func (p *hostPattern) wildcards() int {
+	n := 0
+	for _, label := range p.labels {
+		if label == "*" {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *hostPattern) match(host string) ([]string, bool) {
+	labels := strings.Split(host, ".")
+	if len(labels) != len(p.labels) {
+		return nil, false
+	}
+	var captures []string
+	for i, label := range p.labels {
+		if label == "*" {
+			if labels[i] == "" || strings.ContainsAny(labels[i], `/\`) {
+				return nil, false
+			}
+			captures = append(captures, labels[i])
+		} else if label != labels[i] {
+			return nil, false
+		}
+	}
+	return captures, true
+}
+
+// expand returns the host name matched with the given captures
+func (p *hostPattern) expand(captures []string) string {
+	labels := make([]string, len(p.labels))
+	n := 0
+	for i, label := range p.labels {
+		if label == "*" && n < len(captures) {
+			label = captures[n]
+			n++
+		}
+		labels[i] = label
+	}
+	return strings.Join(labels, ".")
+}
+
+// isDynamicDirectory returns whether the directory contains placeholders like {1}
+func isDynamicDirectory(directory string) bool {
+	return directoryPlaceholder.MatchString(directory)
+}
+
+// maxPlaceholder returns the highest placeholder used in the directory
+func maxPlaceholder(directory string) int {
+	max := 0
+	for _, m := range directoryPlaceholder.FindAllStringSubmatch(directory, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// expandDirectory replaces the placeholders {1}, {2}, ... by the labels matched by the wildcards
+func expandDirectory(directory string, captures []string) string {
+	return directoryPlaceholder.ReplaceAllStringFunc(directory, func(placeholder string) string {
+		n, _ := strconv.Atoi(placeholder[1 : len(placeholder)-1])
+		if n < 1 || n > len(captures) {
+			return placeholder
+		}
+		return captures[n-1]
+	})
+}
+
+type cachedSite struct {
+	handler http.Handler
+	onClose func()
+	lastUsed time.Time
+	active int
+}
+
+// siteBuild marks a site which is being built, done is closed once site is set (nil if the build failed)
+type siteBuild struct {
+	done chan bool
+	site *cachedSite
+}
+
+// siteCache lazily creates the handlers of sites with dynamic directories
+// and closes them once they have been idle for longer than idleTimeout.
+// Sites are built outside the lock, so that building one doesn't stall the requests of the others.
+type siteCache struct {
+	build func(directory string, host string) (http.Handler, func(), error)
+	idleTimeout time.Duration
+	lock sync.Mutex
+	sites map[string]*cachedSite
+	building map[string]*siteBuild
+	closed bool
+	stop chan bool
+}
+
+func newSiteCache(idleTimeout time.Duration, build func(directory string, host string) (http.Handler, func(), error)) *siteCache {
+	c := &siteCache{
+		build: build,
+		idleTimeout: idleTimeout,
+		sites: map[string]*cachedSite{},
+		building: map[string]*siteBuild{},
+		stop: make(chan bool),
+	}
+	go c.evictIdle()
+	return c
+}
+
+// handler returns the handler serving the directory, nil if the directory doesn't exist
+func (c *siteCache) handler(directory string, host string) http.Handler {
+	site := c.site(directory, host)
+	if site == nil {
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.use(site, 1)
+		defer c.use(site, -1)
+		site.handler.ServeHTTP(w, r)
+	})
+}
+
+// site returns the cached site of the directory, building it if needed.
+// Concurrent requests for a directory which is being built wait for that build instead of starting another one.
+func (c *siteCache) site(directory string, host string) *cachedSite {
+	c.lock.Lock()
+	for !c.closed {
+		if site, ok := c.sites[directory]; ok {
+			site.lastUsed = time.Now()
+			c.lock.Unlock()
+			return site
+		}
+		if b, ok := c.building[directory]; ok {
+			c.lock.Unlock()
+			<-b.done
+			if b.site == nil {
+				return nil
+			}
+			c.lock.Lock()
+			continue
+		}
+		b := &siteBuild{done: make(chan bool)}
+		c.building[directory] = b
+		c.lock.Unlock()
+		b.site = c.buildSite(directory, host)
+		c.lock.Lock()
+		delete(c.building, directory)
+		close(b.done)
+		if b.site == nil {
+			c.lock.Unlock()
+			return nil
+		}
+		if c.closed {
+			if b.site.onClose != nil {
+				b.site.onClose()
+			}
+			break
+		}
+		c.sites[directory] = b.site
+	}
+	c.lock.Unlock()
+	return nil
+}
+
+// buildSite creates the site of the directory, nil if it doesn't exist or can't be served
+func (c *siteCache) buildSite(directory string, host string) *cachedSite {
+	if fi, err := os.Stat(directory); err != nil || !fi.IsDir() {
+		return nil
+	}
+	handler, onClose, err := c.build(directory, host)
+	if err != nil {
+		log.Printf("Could not serve %s: %v", directory, err)
+		return nil
+	}
+	return &cachedSite{handler: handler, onClose: onClose}
+}
+
+func (c *siteCache) use(site *cachedSite, delta int) {
+	c.lock.Lock()
+	site.active += delta
+	site.lastUsed = time.Now()
+	c.lock.Unlock()
+}
+
+func (c *siteCache) evictIdle() {
+	interval := c.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.evict(now)
+		}
+	}
+}
+
+// evict closes the sites which are not in use and have been idle for longer than idleTimeout
+func (c *siteCache) evict(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for directory, site := range c.sites {
+		if site.active == 0 && now.Sub(site.lastUsed) > c.idleTimeout {
+			log.Printf("Evicting idle site %s", directory)
+			delete(c.sites, directory)
+			if site.onClose != nil {
+				site.onClose()
+			}
+		}
+	}
+}
+
+// close closes all cached sites, no new sites are created afterwards
+func (c *siteCache) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.stop)
+	for directory, site := range c.sites {
+		delete(c.sites, directory)
+		if site.onClose != nil {
+			site.onClose()
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostPattern(t *testing.T) {
+	pattern, err := parseHostPattern("*.preview.example.com")
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	tests := []struct {
+		host string
+		matches bool
+		capture string
+	}{
+		{host: "pr-12.preview.example.com", matches: true, capture: "pr-12"},
+		{host: "preview.example.com", matches: false},
+		{host: "a.pr-12.preview.example.com", matches: false},
+		{host: ".preview.example.com", matches: false},
+		{host: "pr-12.preview.example.org", matches: false},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			captures, ok := pattern.match(test.host)
+			if ok != test.matches {
+				t.Fatalf("Expected match %v but got %v", test.matches, ok)
+			}
+			if ok && (len(captures) != 1 || captures[0] != test.capture) {
+				t.Fatalf("Expected captures [%v] but got %v", test.capture, captures)
+			}
+			if ok && pattern.expand(captures) != test.host {
+				t.Fatalf("Expected %v but got %v", test.host, pattern.expand(captures))
+			}
+		})
+	}
+	if _, err := parseHostPattern("pr-*.example.com"); err == nil {
+		t.Fatalf("Expected an error for a partial label wildcard")
+	}
+}
+
+func TestExpandDirectory(t *testing.T) {
+	directory := expandDirectory("/srv/{2}/previews/{1}", []string{"pr-12", "app"})
+	if directory != "/srv/app/previews/pr-12" {
+		t.Fatalf("Expected %v but got %v", "/srv/app/previews/pr-12", directory)
+	}
+	if maxPlaceholder("/srv/{2}/previews/{1}") != 2 {
+		t.Fatalf("Expected %v but got %v", 2, maxPlaceholder("/srv/{2}/previews/{1}"))
+	}
+	if isDynamicDirectory("/srv/previews") {
+		t.Fatalf("Expected /srv/previews not to be dynamic")
+	}
+}
+
+func TestWildcardVirtualHosts(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	err := os.Mkdir(tempDir + "/pr-12", 0755)
+	if err != nil {
+		t.Fatalf("expected no error got %v", err)
+	}
+	writeFile(tempDir + "/pr-12/index.txt", []byte("preview 12"))
+
+	built := 0
+	closed := 0
+	cache := newSiteCache(time.Minute, func(directory string, host string) (http.Handler, func(), error) {
+		built++
+		return http.FileServer(justFilesFilesystem{fs: http.Dir(directory)}), func() { closed++ }, nil
+	})
+	defer cache.close()
+	pattern, _ := parseHostPattern("*.preview.example.com")
+	pattern.handler = func(captures []string) http.Handler {
+		return cache.handler(expandDirectory(tempDir + "/{1}", captures), pattern.expand(captures))
+	}
+	h := HandleVirtualHosts(nil, []*hostPattern{pattern}, http.NotFoundHandler())
+
+	get := func(host string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/index.txt", nil)
+		r.Host = host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := get("pr-12.preview.example.com")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "preview 12") {
+		t.Fatalf("Expected preview 12 but got %v %v", rec.Code, rec.Body.String())
+	}
+	get("pr-12.preview.example.com")
+	if built != 1 {
+		t.Fatalf("Expected the site to be built once but was built %v times", built)
+	}
+	rec = get("pr-13.preview.example.com")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected %v for a missing directory but got %v", http.StatusNotFound, rec.Code)
+	}
+
+	cache.evict(time.Now())
+	if closed != 0 {
+		t.Fatalf("Expected recently used site to be kept")
+	}
+	cache.evict(time.Now().Add(2 * time.Minute))
+	if closed != 1 {
+		t.Fatalf("Expected idle site to be closed")
+	}
+	get("pr-12.preview.example.com")
+	if built != 2 {
+		t.Fatalf("Expected the evicted site to be built again")
+	}
+}
+
+func TestSiteCacheBuildsOutsideLock(t *testing.T) {
+	tempDir := setupFS()
+	defer cleanTempDir(tempDir)
+	for _, dir := range []string{"/slow", "/fast"} {
+		if err := os.Mkdir(tempDir + dir, 0755); err != nil {
+			t.Fatalf("expected no error got %v", err)
+		}
+	}
+
+	var builds int32
+	release := make(chan bool)
+	cache := newSiteCache(time.Minute, func(directory string, host string) (http.Handler, func(), error) {
+		atomic.AddInt32(&builds, 1)
+		if strings.HasSuffix(directory, "/slow") {
+			<-release
+		}
+		return http.NotFoundHandler(), nil, nil
+	})
+	defer cache.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cache.handler(tempDir + "/slow", "slow.example.com") == nil {
+				t.Errorf("Expected a handler for the slow site")
+			}
+		}()
+	}
+	done := make(chan bool)
+	go func() {
+		cache.handler(tempDir + "/fast", "fast.example.com")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the fast site not to wait for the slow one")
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&builds); n != 2 {
+		t.Fatalf("Expected each site to be built once but got %v builds", n)
+	}
+}